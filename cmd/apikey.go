@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/example/wpp-wave-bot/internal/auth"
+)
+
+// runAPIKey implements the apikey subcommand:
+//
+//	apikey create -name NAME (-company ID | -admin)
+//	apikey list
+//	apikey revoke ID
+func runAPIKey(ctx context.Context, pool *pgxpool.Pool, args []string) error {
+	repo := auth.NewRepository(pool)
+	if len(args) == 0 {
+		return fmt.Errorf("usage: apikey create|list|revoke")
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+		name := fs.String("name", "", "description of the key")
+		company := fs.String("company", "", "company the key is scoped to")
+		admin := fs.Bool("admin", false, "grant access to every company and key management")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *name == "" || (*company == "") == !*admin {
+			return fmt.Errorf("usage: apikey create -name NAME (-company ID | -admin)")
+		}
+		key, k, err := repo.Create(ctx, *name, *company, *admin)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created api key %d, store it now as it won't be shown again:\n%s\n", k.ID, key)
+	case "list":
+		keys, err := repo.List(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tSCOPE\tPREFIX\tCREATED\tLAST USED\tREVOKED")
+		for _, k := range keys {
+			scope := k.CompanyID
+			if k.Admin {
+				scope = "admin"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name, scope, k.Prefix,
+				k.CreatedAt.Format("2006-01-02 15:04"), formatTime(k.LastUsedAt), formatTime(k.RevokedAt))
+		}
+		return tw.Flush()
+	case "revoke":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: apikey revoke ID")
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid key id %q", args[1])
+		}
+		if err := repo.Revoke(ctx, id); err != nil {
+			return err
+		}
+		fmt.Printf("revoked api key %d\n", id)
+	default:
+		return fmt.Errorf("unknown apikey command %s", args[0])
+	}
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04")
+}
@@ -5,7 +5,9 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -13,14 +15,20 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/example/wpp-wave-bot/internal/api"
+	"github.com/example/wpp-wave-bot/internal/auth"
 	"github.com/example/wpp-wave-bot/internal/db"
 	"github.com/example/wpp-wave-bot/internal/db/seeders"
+	"github.com/example/wpp-wave-bot/internal/logging"
+	"github.com/example/wpp-wave-bot/internal/metrics"
 	"github.com/example/wpp-wave-bot/internal/rabbitmq"
+	"github.com/example/wpp-wave-bot/internal/rpc"
+	"github.com/example/wpp-wave-bot/internal/tracing"
+	"github.com/example/wpp-wave-bot/internal/webhooks"
 	"github.com/example/wpp-wave-bot/internal/whatsapp"
 )
 
 func main() {
-	// Setup logger with human friendly console output
+	// Log to the console until the configured output is set up
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
 
@@ -28,11 +36,29 @@ func main() {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath("./")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
+	viper.SetDefault("default_country", "55")
+	viper.SetDefault("number_check_ttl", "24h")
+	viper.SetDefault("drain_timeout", "10s")
+	viper.SetDefault("shutdown_timeout", "15s")
+	viper.SetDefault("lease_ttl", "30s")
+	viper.SetDefault("schedule_interval", "5s")
+	viper.SetDefault("tracing.sample_ratio", 1.0)
+	viper.SetDefault("tracing.service_name", "wpp-wave-bot")
 
 	if err := viper.ReadInConfig(); err != nil {
 		log.Warn().Err(err).Msg("unable to read config file, relying on env vars")
 	}
+	if err := logging.Setup(logging.Config{
+		Level:          viper.GetString("log.level"),
+		Format:         viper.GetString("log.format"),
+		WhatsmeowLevel: viper.GetString("log.whatsmeow_level"),
+		RedactContent:  viper.GetBool("log.redact_content"),
+		RedactPhones:   viper.GetBool("log.redact_phones"),
+	}); err != nil {
+		log.Fatal().Err(err).Msg("invalid logging config")
+	}
 
 	// Parse command, default to run service
 	cmd := "run"
@@ -40,6 +66,14 @@ func main() {
 		cmd = os.Args[1]
 	}
 
+	// exit with an error once every deferred cleanup ran
+	failed := false
+	defer func() {
+		if failed {
+			os.Exit(1)
+		}
+	}()
+
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
@@ -65,6 +99,14 @@ func main() {
 		}
 		log.Info().Msg("seeding completed")
 		return
+	case "apikey":
+		if err := db.Migrate(viper.GetString("database_url"), "internal/db/migrations"); err != nil {
+			log.Fatal().Err(err).Msg("failed to run migrations")
+		}
+		if err := runAPIKey(ctx, dbPool, os.Args[2:]); err != nil {
+			log.Fatal().Err(err).Msg("apikey command failed")
+		}
+		return
 	case "run":
 		if err := db.Migrate(viper.GetString("database_url"), "internal/db/migrations"); err != nil {
 			log.Fatal().Err(err).Msg("failed to run migrations")
@@ -74,6 +116,25 @@ func main() {
 		log.Fatal().Msgf("unknown command %s", cmd)
 	}
 
+	// Export traces when an exporter is configured
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		Exporter:    viper.GetString("tracing.exporter"),
+		Endpoint:    viper.GetString("tracing.endpoint"),
+		Insecure:    viper.GetBool("tracing.insecure"),
+		SampleRatio: viper.GetFloat64("tracing.sample_ratio"),
+		ServiceName: viper.GetString("tracing.service_name"),
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to set up tracing")
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Error().Err(err).Msg("failed to flush traces")
+		}
+	}()
+
 	// Initialize RabbitMQ
 	mq, err := rabbitmq.New(viper.GetString("rabbitmq_url"))
 	if err != nil {
@@ -82,13 +143,68 @@ func main() {
 	defer mq.Close()
 
 	// Initialize WhatsApp handler
-	wa, err := whatsapp.New(dbPool, viper.GetString("database_url"), mq)
+	hooks := webhooks.NewDispatcher(webhooks.NewRepository(dbPool), webhooks.Config{
+		MaxAttempts: viper.GetInt("webhook_max_attempts"),
+		MaxFailures: viper.GetInt("webhook_max_failures"),
+		Timeout:     viper.GetDuration("webhook_timeout"),
+		Workers:     viper.GetInt("webhook_workers"),
+		QueueSize:   viper.GetInt("webhook_queue_size"),
+	})
+	wa, err := whatsapp.New(dbPool, viper.GetString("database_url"), mq, hooks, whatsapp.Config{
+		DefaultCountry:      viper.GetString("default_country"),
+		NumberCheckTTL:      viper.GetDuration("number_check_ttl"),
+		WatchdogInterval:    viper.GetDuration("watchdog_interval"),
+		DegradedAfter:       viper.GetDuration("session_degraded_after"),
+		ReconnectBackoffMin: viper.GetDuration("reconnect_backoff_min"),
+		ReconnectBackoffMax: viper.GetDuration("reconnect_backoff_max"),
+		DrainTimeout:        viper.GetDuration("drain_timeout"),
+		InstanceID:          viper.GetString("instance_id"),
+		LeaseTTL:            viper.GetDuration("lease_ttl"),
+		ScheduleInterval:    viper.GetDuration("schedule_interval"),
+		Limits: whatsapp.Limits{
+			Global:    rateLimit("rate_limit.global"),
+			Company:   rateLimit("rate_limit.company"),
+			Recipient: rateLimit("rate_limit.recipient"),
+			MaxWait:   viper.GetDuration("rate_limit.max_wait"),
+			JitterMin: viper.GetDuration("rate_limit.jitter_min"),
+			JitterMax: viper.GetDuration("rate_limit.jitter_max"),
+		},
+	})
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to init whatsapp client")
 	}
 
+	metrics.RegisterSessions(func() map[string]int {
+		c := wa.Connectivity()
+		return map[string]int{
+			"loaded":       c.Loaded,
+			"connected":    c.Connected,
+			"disconnected": len(c.Disconnected),
+			"degraded":     len(c.Degraded),
+		}
+	})
+
+	// validate bearer JWTs when a key set is configured
+	var jwtVerifier *auth.JWTVerifier
+	if viper.GetString("jwt.jwks_url") != "" || viper.GetString("jwt.jwks_file") != "" {
+		jwtVerifier, err = auth.NewJWTVerifier(ctx, auth.JWTConfig{
+			JWKSURL:         viper.GetString("jwt.jwks_url"),
+			JWKSFile:        viper.GetString("jwt.jwks_file"),
+			Issuer:          viper.GetString("jwt.issuer"),
+			Audience:        viper.GetString("jwt.audience"),
+			CompanyClaim:    viper.GetString("jwt.company_claim"),
+			RolesClaim:      viper.GetString("jwt.roles_claim"),
+			AdminRole:       viper.GetString("jwt.admin_role"),
+			RefreshInterval: viper.GetDuration("jwt.refresh_interval"),
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to load jwks")
+		}
+	}
+
 	// start admin API server
-	apiSrv := api.New(wa)
+	apiSrv := api.New(wa, dbPool, mq, jwtVerifier)
+	apiSrv.AllowOrigins(viper.GetStringSlice("ws_allowed_origins"))
 	go func() {
 		addr := viper.GetString("http_addr")
 		if addr == "" {
@@ -100,7 +216,48 @@ func main() {
 		}
 	}()
 
+	// start gRPC server
+	rpcSrv := rpc.New(wa, auth.NewAuthenticator(auth.NewRepository(dbPool), jwtVerifier))
+	go func() {
+		addr := viper.GetString("grpc_addr")
+		if addr == "" {
+			addr = ":9090"
+		}
+		if err := rpcSrv.Start(addr); err != nil {
+			log.Error().Err(err).Msg("grpc server exited")
+			stop()
+		}
+	}()
+
+	// Start returns once ctx is done and the in-flight send finished
 	if err := wa.Start(ctx); err != nil {
-		log.Fatal().Err(err).Msg("whatsapp service exited")
+		log.Error().Err(err).Msg("whatsapp service exited")
+		failed = true
+		stop()
+	}
+
+	// Shut down in order: the APIs, the WhatsApp clients and their leases,
+	// then the pending webhook deliveries. RabbitMQ and the pool are closed by the deferred
+	// calls above.
+	log.Info().Msg("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), viper.GetDuration("shutdown_timeout"))
+	defer cancel()
+	if err := apiSrv.Shutdown(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("failed to shut down api server")
+	}
+	if err := rpcSrv.Shutdown(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("failed to shut down grpc server")
+	}
+	wa.Close(shutdownCtx)
+	if err := hooks.Wait(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("webhook deliveries still pending")
+	}
+}
+
+// rateLimit reads the token bucket configured under key.
+func rateLimit(key string) whatsapp.Limit {
+	return whatsapp.Limit{
+		PerMinute: viper.GetFloat64(key + ".per_minute"),
+		Burst:     viper.GetInt(key + ".burst"),
 	}
 }
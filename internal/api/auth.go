@@ -0,0 +1,143 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/example/wpp-wave-bot/internal/auth"
+)
+
+// authenticate rejects requests without valid credentials: an API key sent
+// as a bearer token or in the X-API-Key header, or a bearer JWT when a JWKS
+// is configured. Event streams also accept the access_token query parameter.
+// The health checks and the OpenAPI spec stay public.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPublic(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := r.Header.Get("X-API-Key")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			key = bearer
+		}
+		// Browsers can't set headers on EventSource and WebSocket requests.
+		if key == "" && strings.HasPrefix(r.URL.Path, "/events") {
+			key = r.URL.Query().Get("access_token")
+		}
+		if key == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "missing credentials")
+			return
+		}
+
+		p, err := s.authn.Authenticate(r.Context(), key)
+		if errors.Is(err, auth.ErrInvalidKey) || errors.Is(err, auth.ErrInvalidToken) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, r, http.StatusUnauthorized, codeUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			zerolog.Ctx(r.Context()).Error().Err(err).Msg("failed to authenticate request")
+			writeError(w, r, http.StatusInternalServerError, codeInternal, "authentication failed")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), p)))
+	})
+}
+
+// isPublic reports whether path is served without credentials.
+func isPublic(path string) bool {
+	switch path {
+	case "/health", "/livez", "/readyz", "/openapi.json":
+		return true
+	}
+	return false
+}
+
+// company guards routes scoped to the {company} path value.
+func (s *Server) company(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, r.PathValue("company")) {
+			return
+		}
+		h(w, r)
+	}
+}
+
+// admin guards routes requiring admin scope.
+func (s *Server) admin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := auth.FromContext(r.Context())
+		if !ok || !p.Admin {
+			writeError(w, r, http.StatusForbidden, codeForbidden, "admin scope required")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// authorize checks the caller may act on the company, writing a 403 otherwise.
+func authorize(w http.ResponseWriter, r *http.Request, companyID string) bool {
+	p, ok := auth.FromContext(r.Context())
+	if !ok || !p.CanAccess(companyID) {
+		writeError(w, r, http.StatusForbidden, codeForbidden, "access to company denied")
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleListKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.keyRepo.List(r.Context())
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]auth.APIKey{"api_keys": keys})
+}
+
+func (s *Server) handleCreateKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string `json:"name"`
+		CompanyID string `json:"company_id"`
+		Admin     bool   `json:"admin"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Name == "" || (req.CompanyID == "") == !req.Admin {
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, "name and either company_id or admin are required")
+		return
+	}
+	key, k, err := s.keyRepo.Create(r.Context(), req.Name, req.CompanyID, req.Admin)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, struct {
+		*auth.APIKey
+		Key string `json:"key"`
+	}{k, key})
+}
+
+func (s *Server) handleRevokeKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, codeAPIKeyNotFound, auth.ErrKeyNotFound.Error())
+		return
+	}
+	err = s.keyRepo.Revoke(r.Context(), id)
+	if errors.Is(err, auth.ErrKeyNotFound) {
+		writeError(w, r, http.StatusNotFound, codeAPIKeyNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
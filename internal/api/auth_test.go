@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/example/wpp-wave-bot/internal/auth"
+)
+
+// fakeKeys is an in-memory auth.KeyStore.
+type fakeKeys map[string]struct {
+	principal auth.Principal
+	revoked   bool
+}
+
+func (f fakeKeys) Authenticate(_ context.Context, key string) (*auth.Principal, error) {
+	k, ok := f[key]
+	if !ok || k.revoked {
+		return nil, auth.ErrInvalidKey
+	}
+	p := k.principal
+	return &p, nil
+}
+
+func TestAPIKeyAuthorization(t *testing.T) {
+	s := New(nil, nil, nil, nil)
+	s.authn = auth.NewAuthenticator(fakeKeys{
+		"wwb_company": {principal: auth.Principal{Subject: "apikey:1", CompanyID: "empresa-123"}},
+		"wwb_admin":   {principal: auth.Principal{Subject: "apikey:2", Admin: true}},
+		"wwb_revoked": {principal: auth.Principal{Subject: "apikey:3", Admin: true}, revoked: true},
+	}, nil)
+	h := s.Handler()
+
+	// Bodies are invalid so requests that get through stop at validation
+	// instead of reaching the missing backends.
+	const hook = `{"url":"https://93.184.216.34/hook","events":["message.deleted"]}`
+	const key = `{}`
+	tests := []struct {
+		name   string
+		key    string
+		header string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"own company", "wwb_company", "Authorization", "POST", "/webhooks/empresa-123", hook, http.StatusBadRequest},
+		{"other company", "wwb_company", "Authorization", "POST", "/webhooks/empresa-456", hook, http.StatusForbidden},
+		{"other company in body", "wwb_company", "X-API-Key", "POST", "/messages", `{"company_id":"empresa-456","to":"5511999999999","type":"text","message":"oi"}`, http.StatusForbidden},
+		{"company key on admin route", "wwb_company", "X-API-Key", "POST", "/apikeys", key, http.StatusForbidden},
+		{"admin on any company", "wwb_admin", "Authorization", "POST", "/webhooks/empresa-456", hook, http.StatusBadRequest},
+		{"admin route", "wwb_admin", "X-API-Key", "POST", "/apikeys", key, http.StatusBadRequest},
+		{"company key scraping metrics", "wwb_company", "Authorization", "GET", "/metrics", "", http.StatusForbidden},
+		{"admin scraping metrics", "wwb_admin", "Authorization", "GET", "/metrics", "", http.StatusOK},
+		{"anonymous scrape", "", "X-API-Key", "GET", "/metrics", "", http.StatusUnauthorized},
+		{"unknown key", "wwb_unknown", "Authorization", "POST", "/webhooks/empresa-123", hook, http.StatusUnauthorized},
+		{"revoked key", "wwb_revoked", "X-API-Key", "POST", "/apikeys", key, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.header == "Authorization" {
+				req.Header.Set("Authorization", "Bearer "+tt.key)
+			} else {
+				req.Header.Set(tt.header, tt.key)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Error("401 without a WWW-Authenticate challenge")
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/rs/zerolog"
+
+	"github.com/example/wpp-wave-bot/internal/whatsapp"
+)
+
+// maxBodyBytes caps the size of request bodies.
+const maxBodyBytes = 1 << 20
+
+// Stable error codes returned in the error envelope.
+const (
+	codeNotFound            = "not_found"
+	codeMethodNotAllowed    = "method_not_allowed"
+	codeUnauthorized        = "unauthorized"
+	codeForbidden           = "forbidden"
+	codeInvalidJSON         = "invalid_json"
+	codeBodyTooLarge        = "body_too_large"
+	codeValidationFailed    = "validation_failed"
+	codeInvalidParameter    = "invalid_parameter"
+	codeSessionNotFound     = "session_not_found"
+	codeNotPaired           = "not_paired"
+	codeSessionBanned       = "session_banned"
+	codeOwnerUnavailable    = "owner_unavailable"
+	codeRateLimited         = "rate_limited"
+	codeInvalidRecipient    = "invalid_recipient"
+	codeMediaDownloadFailed = "media_download_failed"
+	codeWebhookNotFound     = "webhook_not_found"
+	codeAPIKeyNotFound      = "api_key_not_found"
+	codeScheduledNotFound   = "scheduled_message_not_found"
+	codeNotScheduled        = "not_scheduled"
+	codeInternal            = "internal_error"
+)
+
+// errorBody is the JSON envelope of every error response.
+type errorBody struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Field     string `json:"field,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeJSON(w, status, errorBody{Error: apiError{Code: code, Message: message, RequestID: requestID(r.Context())}})
+}
+
+// writeServiceError maps errors of the service layer to stable codes. Errors
+// without a mapping are logged and reported as internal errors so raw
+// whatsmeow and database errors never reach the caller.
+func writeServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	var invalid *whatsapp.InvalidRecipientError
+	var validation *whatsapp.ValidationError
+	var limited *whatsapp.RateLimitError
+	switch {
+	case errors.As(err, &validation):
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: apiError{
+			Code:      codeValidationFailed,
+			Message:   validation.Error(),
+			Field:     validation.Field,
+			RequestID: requestID(r.Context()),
+		}})
+	case errors.As(err, &invalid):
+		writeError(w, r, http.StatusBadRequest, codeInvalidRecipient, invalid.Error())
+	case errors.As(err, &limited):
+		w.Header().Set("Retry-After", strconv.Itoa(int(limited.RetryAfter.Seconds())+1))
+		writeError(w, r, http.StatusTooManyRequests, codeRateLimited, limited.Error())
+	case errors.Is(err, whatsapp.ErrSessionNotFound):
+		writeError(w, r, http.StatusNotFound, codeSessionNotFound, err.Error())
+	case errors.Is(err, whatsapp.ErrNotPaired):
+		writeError(w, r, http.StatusConflict, codeNotPaired, err.Error())
+	case errors.Is(err, whatsapp.ErrSessionBanned):
+		writeError(w, r, http.StatusConflict, codeSessionBanned, err.Error())
+	case errors.Is(err, whatsapp.ErrOwnerUnavailable):
+		writeError(w, r, http.StatusServiceUnavailable, codeOwnerUnavailable, err.Error())
+	case errors.Is(err, whatsapp.ErrMediaDownload):
+		// The cause may hold the media URL and the remote server's answer.
+		zerolog.Ctx(r.Context()).Warn().Err(err).Str("path", r.URL.Path).Msg("media download failed")
+		writeError(w, r, http.StatusBadGateway, codeMediaDownloadFailed, whatsapp.ErrMediaDownload.Error())
+	default:
+		zerolog.Ctx(r.Context()).Error().Err(err).Str("path", r.URL.Path).Msg("request failed")
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "internal error")
+	}
+}
+
+// decodeJSON reads a size limited JSON body into v, writing the error
+// response itself when that fails.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, codeBodyTooLarge, "request body too large")
+			return false
+		}
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "invalid json")
+		return false
+	}
+	return true
+}
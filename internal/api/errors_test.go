@@ -0,0 +1,32 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/example/wpp-wave-bot/internal/whatsapp"
+)
+
+func TestMediaDownloadErrorHidesCause(t *testing.T) {
+	err := fmt.Errorf("%w: Get \"http://10.0.0.7/private/file.jpg\": dial tcp 10.0.0.7:80: connection refused", whatsapp.ErrMediaDownload)
+	rec := httptest.NewRecorder()
+	writeServiceError(rec, httptest.NewRequest("POST", "/messages", nil), err)
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", rec.Code)
+	}
+	var body errorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.Code != codeMediaDownloadFailed || body.Error.Message != "media download failed" {
+		t.Errorf("error = %+v", body.Error)
+	}
+	if strings.Contains(rec.Body.String(), "10.0.0.7") {
+		t.Errorf("response leaks the cause: %s", rec.Body)
+	}
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+
+	"github.com/example/wpp-wave-bot/internal/auth"
+	"github.com/example/wpp-wave-bot/internal/events"
+)
+
+// keepAlive is how often idle event streams are pinged so proxies don't
+// close them.
+const keepAlive = 25 * time.Second
+
+// checkOrigin lets WebSocket upgrades through from the API's own origin, the
+// configured allowed origins and clients that send no Origin, which aren't
+// browsers. A page on another site could otherwise use a token it obtained to
+// open a stream from the user's browser.
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range s.origins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), u.Scheme+"://"+u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribe checks the company_id and events query parameters of an event
+// stream request and subscribes to the matching events. It writes the error
+// response itself and returns ok=false when the request is rejected.
+func (s *Server) subscribe(w http.ResponseWriter, r *http.Request) (<-chan events.Event, func(), func(events.Event) bool, bool) {
+	companyID := r.URL.Query().Get("company_id")
+	if companyID == "" {
+		if p, _ := auth.FromContext(r.Context()); !p.Admin {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "company_id is required without admin scope")
+			return nil, nil, nil, false
+		}
+	} else if !authorize(w, r, companyID) {
+		return nil, nil, nil, false
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(r.URL.Query().Get("events"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			wanted[name] = true
+		}
+	}
+	match := func(evt events.Event) bool {
+		return len(wanted) == 0 || wanted[evt.Name]
+	}
+
+	ch, unsubscribe := s.wa.Subscribe(companyID)
+	return ch, unsubscribe, match, true
+}
+
+// handleEvents streams events as Server-Sent Events.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "streaming unsupported")
+		return
+	}
+	ch, unsubscribe, match, ok := s.subscribe(w, r)
+	if !ok {
+		return
+	}
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.closing:
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case evt := <-ch:
+			if !match(evt) {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evt.Name, evt.Payload)
+			flusher.Flush()
+		}
+	}
+}
+
+// handleEventsWS streams events over a WebSocket, one JSON message per event.
+func (s *Server) handleEventsWS(w http.ResponseWriter, r *http.Request) {
+	ch, unsubscribe, match, ok := s.subscribe(w, r)
+	if !ok {
+		return
+	}
+	defer unsubscribe()
+
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already wrote the error response.
+		return
+	}
+	defer conn.Close()
+
+	// The client doesn't send anything; reading detects when it goes away
+	// and handles pongs and close frames.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadDeadline(time.Now().Add(2 * keepAlive))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * keepAlive))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-s.closing:
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		case evt := <-ch:
+			if !match(evt) {
+				continue
+			}
+			msg, _ := json.Marshal(evt)
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				zerolog.Ctx(r.Context()).Debug().Err(err).Msg("event stream closed")
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) handlePresence(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		JID string `json:"jid"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.JID == "" {
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, "jid is required")
+		return
+	}
+	jid, err := s.wa.SubscribePresence(r.Context(), r.PathValue("company"), req.JID)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"jid": jid})
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"no origin", nil, "", true},
+		{"same origin", nil, "https://bot.example.com", true},
+		{"same origin different case", nil, "https://BOT.example.com", true},
+		{"other site", nil, "https://evil.example.net", false},
+		{"other port", nil, "https://bot.example.com:8443", false},
+		{"malformed", nil, "null", false},
+		{"allowed", []string{"https://console.example.com"}, "https://console.example.com", true},
+		{"allowed with trailing slash", []string{"https://console.example.com/"}, "https://console.example.com", true},
+		{"allowed other scheme", []string{"https://console.example.com"}, "http://console.example.com", false},
+		{"not allowed", []string{"https://console.example.com"}, "https://evil.example.net", false},
+		{"wildcard", []string{"*"}, "https://evil.example.net", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{}
+			s.AllowOrigins(tt.allowed)
+			r := httptest.NewRequest("GET", "https://bot.example.com/events/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := s.checkOrigin(r); got != tt.want {
+				t.Errorf("checkOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
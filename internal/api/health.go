@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/example/wpp-wave-bot/internal/whatsapp"
+)
+
+// checkTimeout bounds each dependency check of /readyz.
+const checkTimeout = 2 * time.Second
+
+// check is the outcome of a dependency check.
+type check struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// readiness is the body of /readyz/details.
+type readiness struct {
+	Status   string                `json:"status"`
+	Checks   map[string]check      `json:"checks"`
+	Sessions whatsapp.Connectivity `json:"sessions"`
+}
+
+// readinessSummary is the body of the public /readyz, which leaves out check
+// errors and companies.
+type readinessSummary struct {
+	Status   string           `json:"status"`
+	Checks   map[string]check `json:"checks"`
+	Sessions sessionCounts    `json:"sessions"`
+}
+
+type sessionCounts struct {
+	Loaded       int `json:"loaded"`
+	Connected    int `json:"connected"`
+	Disconnected int `json:"disconnected"`
+	Degraded     int `json:"degraded"`
+}
+
+func (r readiness) summary() readinessSummary {
+	s := readinessSummary{
+		Status: r.Status,
+		Checks: make(map[string]check, len(r.Checks)),
+		Sessions: sessionCounts{
+			Loaded:       r.Sessions.Loaded,
+			Connected:    r.Sessions.Connected,
+			Disconnected: len(r.Sessions.Disconnected),
+			Degraded:     len(r.Sessions.Degraded),
+		},
+	}
+	for name, c := range r.Checks {
+		s.Checks[name] = check{Status: c.Status}
+	}
+	return s
+}
+
+// handleHealth reports "degraded" with the number of affected sessions while
+// any session is down beyond its SLA. It still answers 200 since the process
+// itself is fine. The companies aren't listed as the endpoint is public.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if degraded := s.wa.Degraded(); len(degraded) > 0 {
+		writeJSON(w, http.StatusOK, map[string]any{"status": "degraded", "degraded_sessions": len(degraded)})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+}
+
+// handleLivez answers as long as the process serves requests.
+func (s *Server) handleLivez(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+}
+
+// handleReadyz checks PostgreSQL, RabbitMQ and the whatsmeow store, and
+// answers 503 when any of them is down. Session connectivity is reported
+// but doesn't affect readiness, as a single offline phone shouldn't take
+// the instance out of rotation. Being public, it only reports statuses and
+// counts.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	res, code := s.readiness(r.Context())
+	writeJSON(w, code, res.summary())
+}
+
+// handleReadyzDetails runs the checks of /readyz for admins, with the errors
+// of failed checks and the affected companies.
+func (s *Server) handleReadyzDetails(w http.ResponseWriter, r *http.Request) {
+	res, code := s.readiness(r.Context())
+	writeJSON(w, code, res)
+}
+
+func (s *Server) readiness(ctx context.Context) (readiness, int) {
+	res := readiness{
+		Status: "ok",
+		Checks: map[string]check{
+			"postgres":        runCheck(ctx, s.db.Ping),
+			"rabbitmq":        runCheck(ctx, func(context.Context) error { return s.mq.Check() }),
+			"whatsmeow_store": runCheck(ctx, s.wa.CheckStore),
+		},
+		Sessions: s.wa.Connectivity(),
+	}
+	code := http.StatusOK
+	for _, c := range res.Checks {
+		if c.Status != "ok" {
+			res.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	return res, code
+}
+
+func runCheck(ctx context.Context, fn func(context.Context) error) check {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	if err := fn(ctx); err != nil {
+		return check{Status: "down", Error: err.Error()}
+	}
+	return check{Status: "ok"}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/example/wpp-wave-bot/internal/whatsapp"
+)
+
+func TestReadinessSummary(t *testing.T) {
+	res := readiness{
+		Status: "unavailable",
+		Checks: map[string]check{
+			"postgres": {Status: "ok"},
+			"rabbitmq": {Status: "down", Error: "dial tcp 10.0.0.7:5672: connection refused"},
+		},
+		Sessions: whatsapp.Connectivity{
+			Loaded:       3,
+			Connected:    1,
+			Disconnected: []string{"empresa-456", "empresa-789"},
+			Degraded:     []string{"empresa-789"},
+		},
+	}
+	body, err := json.Marshal(res.summary())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"status":"unavailable","checks":{"postgres":{"status":"ok"},"rabbitmq":{"status":"down"}},` +
+		`"sessions":{"loaded":3,"connected":1,"disconnected":2,"degraded":1}}`
+	if string(body) != want {
+		t.Errorf("summary = %s, want %s", body, want)
+	}
+	var decoded any
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadSpec(t).validate("body", map[string]any{"$ref": "#/components/schemas/Readiness"}, decoded); err != nil {
+		t.Error(err)
+	}
+	for _, leak := range []string{"10.0.0.7", "empresa-"} {
+		if strings.Contains(string(body), leak) {
+			t.Errorf("summary leaks %q: %s", leak, body)
+		}
+	}
+}
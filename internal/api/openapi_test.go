@@ -0,0 +1,387 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/example/wpp-wave-bot/internal/auth"
+	"github.com/example/wpp-wave-bot/internal/webhooks"
+)
+
+// spec is the embedded OpenAPI document, decoded generically.
+type spec map[string]any
+
+func loadSpec(t *testing.T) spec {
+	t.Helper()
+	var doc spec
+	if err := json.Unmarshal(openapiSpec, &doc); err != nil {
+		t.Fatalf("parse openapi spec: %v", err)
+	}
+	return doc
+}
+
+// operations returns the operations of the spec keyed by "METHOD /path".
+func (s spec) operations() map[string]map[string]any {
+	ops := make(map[string]map[string]any)
+	for path, item := range s["paths"].(map[string]any) {
+		for method, op := range item.(map[string]any) {
+			switch method {
+			case "get", "post", "put", "patch", "delete":
+				ops[strings.ToUpper(method)+" "+path] = op.(map[string]any)
+			}
+		}
+	}
+	return ops
+}
+
+// resolve follows a local $ref such as "#/components/schemas/Error".
+func (s spec) resolve(ref string) (map[string]any, bool) {
+	var node any = map[string]any(s)
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		m, ok := node.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if node, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	m, ok := node.(map[string]any)
+	return m, ok
+}
+
+// deref returns the node a $ref points to, or the node itself.
+func (s spec) deref(node map[string]any) map[string]any {
+	for {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		next, ok := s.resolve(ref)
+		if !ok {
+			return node
+		}
+		node = next
+	}
+}
+
+// TestSpecMatchesRoutes fails when the routes registered by the server and
+// the operations of the spec drift apart.
+func TestSpecMatchesRoutes(t *testing.T) {
+	documented := make(map[string]bool)
+	for route := range loadSpec(t).operations() {
+		documented[route] = true
+	}
+
+	var undocumented []string
+	for path, methods := range (&Server{}).routes().methods {
+		for _, method := range methods {
+			route := method + " " + path
+			if !documented[route] {
+				undocumented = append(undocumented, route)
+			}
+			delete(documented, route)
+		}
+	}
+	var missing []string
+	for route := range documented {
+		missing = append(missing, route)
+	}
+	sort.Strings(missing)
+	sort.Strings(undocumented)
+	if len(missing) > 0 || len(undocumented) > 0 {
+		t.Errorf("openapi spec out of sync: not served %v, not documented %v", missing, undocumented)
+	}
+}
+
+func TestSpecRefsResolve(t *testing.T) {
+	doc := loadSpec(t)
+	var walk func(path string, node any)
+	walk = func(path string, node any) {
+		switch n := node.(type) {
+		case map[string]any:
+			if ref, ok := n["$ref"].(string); ok {
+				if _, ok := doc.resolve(ref); !ok {
+					t.Errorf("%s: unresolved $ref %q", path, ref)
+				}
+			}
+			for k, v := range n {
+				walk(path+"/"+k, v)
+			}
+		case []any:
+			for i, v := range n {
+				walk(path+"/"+strconv.Itoa(i), v)
+			}
+		}
+	}
+	walk("#", map[string]any(doc))
+}
+
+// TestSpecErrorResponses checks every operation documents the errors the
+// middleware can answer with.
+func TestSpecErrorResponses(t *testing.T) {
+	for route, op := range loadSpec(t).operations() {
+		responses := op["responses"].(map[string]any)
+		_, path, _ := strings.Cut(route, " ")
+		public, hasSecurity := op["security"].([]any)
+		if hasSecurity && len(public) == 0 {
+			if !isPublic(path) {
+				t.Errorf("%s: documented as public but requires credentials", route)
+			}
+			continue
+		}
+		if isPublic(path) {
+			t.Errorf("%s: public but documented as authenticated", route)
+		}
+		want := []string{"401"}
+		if strings.Contains(path, "{company}") || strings.HasPrefix(path, "/apikeys") {
+			want = append(want, "403")
+		}
+		for _, code := range want {
+			if _, ok := responses[code]; !ok {
+				t.Errorf("%s: %s response not documented", route, code)
+			}
+		}
+	}
+}
+
+// TestResponsesMatchSpec validates the bodies answered by the server against
+// the schemas documented for their status code.
+func TestResponsesMatchSpec(t *testing.T) {
+	doc := loadSpec(t)
+	ops := doc.operations()
+	h, token := newTestHandler(t, "empresa-123")
+
+	tests := []struct {
+		name   string
+		route  string
+		method string
+		target string
+		token  string
+		body   string
+		status int
+	}{
+		{"livez", "GET /livez", "GET", "/livez", "", "", http.StatusOK},
+		{"missing credentials", "GET /sessions", "GET", "/sessions", "", "", http.StatusUnauthorized},
+		{"invalid token", "GET /sessions", "GET", "/sessions", "not.a.jwt", "", http.StatusUnauthorized},
+		{"other company", "GET /messages/{company}", "GET", "/messages/empresa-456", token, "", http.StatusForbidden},
+		{"admin route", "GET /apikeys", "GET", "/apikeys", token, "", http.StatusForbidden},
+		{"metrics without admin scope", "GET /metrics", "GET", "/metrics", token, "", http.StatusForbidden},
+		{"readiness details", "GET /readyz/details", "GET", "/readyz/details", token, "", http.StatusForbidden},
+		{"invalid message", "POST /messages", "POST", "/messages", token, `{"company_id":"empresa-123","to":"5511999999999","type":"text"}`, http.StatusBadRequest},
+		{"message of other company", "POST /messages", "POST", "/messages", token, `{"company_id":"empresa-456","to":"5511999999999","type":"text","message":"oi"}`, http.StatusForbidden},
+		{"malformed json", "POST /messages", "POST", "/messages", token, `{`, http.StatusBadRequest},
+		{"unknown webhook event", "POST /webhooks/{company}", "POST", "/webhooks/empresa-123", token, `{"url":"https://93.184.216.34/hook","events":["message.deleted"]}`, http.StatusBadRequest},
+		{"private webhook target", "POST /webhooks/{company}", "POST", "/webhooks/empresa-123", token, `{"url":"http://169.254.169.254/latest/meta-data"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+
+			op, ok := ops[tt.route]
+			if !ok {
+				t.Fatalf("%s not documented", tt.route)
+			}
+			resp, ok := op["responses"].(map[string]any)[strconv.Itoa(rec.Code)].(map[string]any)
+			if !ok {
+				t.Fatalf("%s: status %d not documented", tt.route, rec.Code)
+			}
+			schema, ok := jsonSchema(doc.deref(resp))
+			if !ok {
+				t.Fatalf("%s: status %d has no JSON schema", tt.route, rec.Code)
+			}
+			var body any
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			if err := doc.validate("body", schema, body); err != nil {
+				t.Errorf("%s: %v\n%s", tt.route, err, rec.Body)
+			}
+		})
+	}
+}
+
+// TestWebhookEventsMatchSpec checks the events accepted for webhooks are the
+// ones documented.
+func TestWebhookEventsMatchSpec(t *testing.T) {
+	schema, ok := loadSpec(t).resolve("#/components/schemas/WebhookRequest")
+	if !ok {
+		t.Fatal("WebhookRequest not documented")
+	}
+	items := schema["properties"].(map[string]any)["events"].(map[string]any)["items"].(map[string]any)
+	documented := asStrings(items["enum"])
+	sort.Strings(documented)
+	accepted := append([]string(nil), webhooks.Events...)
+	sort.Strings(accepted)
+	if strings.Join(documented, ",") != strings.Join(accepted, ",") {
+		t.Errorf("webhook events = %v, documented %v", accepted, documented)
+	}
+}
+
+func jsonSchema(resp map[string]any) (map[string]any, bool) {
+	content, _ := resp["content"].(map[string]any)
+	media, _ := content["application/json"].(map[string]any)
+	schema, ok := media["schema"].(map[string]any)
+	return schema, ok
+}
+
+// validate checks v against the subset of JSON Schema used by the spec.
+// Objects with declared properties may not carry undocumented ones, so new
+// response fields must be added to the spec.
+func (s spec) validate(path string, schema map[string]any, v any) error {
+	schema = s.deref(schema)
+	if all, ok := schema["allOf"].([]any); ok {
+		for _, sub := range all {
+			if err := s.validate(path, sub.(map[string]any), v); err != nil {
+				return err
+			}
+		}
+	}
+	if one, ok := schema["oneOf"].([]any); ok {
+		matched := 0
+		for _, sub := range one {
+			if s.validate(path, sub.(map[string]any), v) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("%s: matches %d schemas of oneOf", path, matched)
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			found = found || e == v
+		}
+		if !found {
+			return fmt.Errorf("%s: %v not in %v", path, v, enum)
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: %T is not an object", path, v)
+		}
+		for _, name := range asStrings(schema["required"]) {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: missing required %q", path, name)
+			}
+		}
+		props, ok := schema["properties"].(map[string]any)
+		if !ok {
+			return nil
+		}
+		for name, val := range obj {
+			prop, ok := props[name].(map[string]any)
+			if !ok {
+				if _, all := schema["allOf"]; !all {
+					return fmt.Errorf("%s: undocumented property %q", path, name)
+				}
+				continue
+			}
+			if err := s.validate(path+"."+name, prop, val); err != nil {
+				return err
+			}
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: %T is not an array", path, v)
+		}
+		items, _ := schema["items"].(map[string]any)
+		for i, item := range arr {
+			if err := s.validate(path+"["+strconv.Itoa(i)+"]", items, item); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("%s: %T is not a string", path, v)
+		}
+	case "integer":
+		if f, ok := v.(float64); !ok || f != float64(int64(f)) {
+			return fmt.Errorf("%s: %v is not an integer", path, v)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%s: %T is not a number", path, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: %T is not a boolean", path, v)
+		}
+	}
+	return nil
+}
+
+func asStrings(v any) []string {
+	list, _ := v.([]any)
+	out := make([]string, 0, len(list))
+	for _, s := range list {
+		out = append(out, s.(string))
+	}
+	return out
+}
+
+// newTestHandler returns the handler of a server without backends, which
+// accepts JWTs signed by a generated key, and a token for the company.
+func newTestHandler(t *testing.T, companyID string) (http.Handler, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kid": "test", "kty": "RSA", "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := auth.NewJWTVerifier(context.Background(), auth.JWTConfig{JWKSFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub":        "tester",
+		"company_id": companyID,
+		"exp":        time.Now().Add(time.Hour).Unix(),
+	})
+	tok.Header["kid"] = "test"
+	signed, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(nil, nil, nil, verifier).Handler(), signed
+}
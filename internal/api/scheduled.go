@@ -0,0 +1,105 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/example/wpp-wave-bot/internal/scheduled"
+)
+
+func (s *Server) handleListScheduled(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	status := q.Get("status")
+	switch status {
+	case "", scheduled.StatusScheduled, scheduled.StatusDispatched, scheduled.StatusFailed, scheduled.StatusCancelled:
+	default:
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "invalid status")
+		return
+	}
+	limit, err := parseLimit(q.Get("limit"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "invalid limit")
+		return
+	}
+	list, err := s.schedRepo.List(r.Context(), r.PathValue("company"), status, limit)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]scheduled.Message{"scheduled": list})
+}
+
+func (s *Server) handleGetScheduled(w http.ResponseWriter, r *http.Request) {
+	id, ok := scheduledID(w, r)
+	if !ok {
+		return
+	}
+	m, err := s.schedRepo.Get(r.Context(), r.PathValue("company"), id)
+	if err != nil {
+		writeScheduledError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (s *Server) handleReschedule(w http.ResponseWriter, r *http.Request) {
+	id, ok := scheduledID(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		SendAt *time.Time `json:"send_at"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.SendAt == nil || !req.SendAt.After(time.Now()) {
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: apiError{
+			Code:      codeValidationFailed,
+			Message:   "send_at must be in the future",
+			Field:     "send_at",
+			RequestID: requestID(r.Context()),
+		}})
+		return
+	}
+	m, err := s.schedRepo.Reschedule(r.Context(), r.PathValue("company"), id, *req.SendAt)
+	if err != nil {
+		writeScheduledError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (s *Server) handleCancelScheduled(w http.ResponseWriter, r *http.Request) {
+	id, ok := scheduledID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.schedRepo.Cancel(r.Context(), r.PathValue("company"), id); err != nil {
+		writeScheduledError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeScheduledError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, scheduled.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, codeScheduledNotFound, err.Error())
+	case errors.Is(err, scheduled.ErrNotPending):
+		writeError(w, r, http.StatusConflict, codeNotScheduled, err.Error())
+	default:
+		writeServiceError(w, r, err)
+	}
+}
+
+func scheduledID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, codeScheduledNotFound, scheduled.ErrNotFound.Error())
+		return 0, false
+	}
+	return id, true
+}
@@ -3,100 +3,325 @@ package api
 import (
 	"context"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/example/wpp-wave-bot/internal/auth"
+	"github.com/example/wpp-wave-bot/internal/messages"
+	"github.com/example/wpp-wave-bot/internal/metrics"
+	"github.com/example/wpp-wave-bot/internal/rabbitmq"
+	"github.com/example/wpp-wave-bot/internal/scheduled"
+	"github.com/example/wpp-wave-bot/internal/webhooks"
 	"github.com/example/wpp-wave-bot/internal/whatsapp"
 )
 
 // Server exposes simple admin endpoints.
 type Server struct {
-	wa *whatsapp.Service
+	wa        *whatsapp.Service
+	db        *pgxpool.Pool
+	mq        *rabbitmq.RabbitMQ
+	msgRepo   *messages.Repository
+	hookRepo  *webhooks.Repository
+	schedRepo *scheduled.Repository
+	keyRepo   *auth.Repository
+	authn     *auth.Authenticator
+	// origins are the origins besides our own allowed to open WebSockets.
+	origins []string
+
+	httpSrv *http.Server
+	// closing is closed on shutdown to end the event streams.
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+// New creates a new Server bound to the WhatsApp service. Bearer JWTs are
+// accepted alongside API keys when jwt is not nil.
+func New(wa *whatsapp.Service, db *pgxpool.Pool, mq *rabbitmq.RabbitMQ, jwt *auth.JWTVerifier) *Server {
+	keyRepo := auth.NewRepository(db)
+	return &Server{
+		wa:        wa,
+		db:        db,
+		mq:        mq,
+		msgRepo:   messages.NewRepository(db),
+		hookRepo:  webhooks.NewRepository(db),
+		schedRepo: scheduled.NewRepository(db),
+		keyRepo:   keyRepo,
+		authn:     auth.NewAuthenticator(keyRepo, jwt),
+		httpSrv:   &http.Server{ReadHeaderTimeout: 10 * time.Second},
+		closing:   make(chan struct{}),
+	}
 }
 
-// New creates a new Server bound to the WhatsApp service.
-func New(wa *whatsapp.Service) *Server {
-	return &Server{wa: wa}
+// AllowOrigins sets the origins, such as https://console.example.com, whose
+// pages may open event WebSockets besides the API's own; "*" allows any.
+func (s *Server) AllowOrigins(origins []string) {
+	s.origins = origins
 }
 
 // Start runs the HTTP server on the given address.
 func (s *Server) Start(addr string) error {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/health", s.handleHealth)
-	mux.HandleFunc("/sessions", s.handleList)
-	mux.HandleFunc("/sessions/", s.handleSession)
-	mux.HandleFunc("/messages", s.handleSend)
-	return http.ListenAndServe(addr, mux)
+	s.httpSrv.Addr = addr
+	s.httpSrv.Handler = s.Handler()
+	return s.httpSrv.ListenAndServe()
+}
+
+// Shutdown ends the event streams and stops the server, waiting for other
+// requests to finish until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closeOnce.Do(func() { close(s.closing) })
+	return s.httpSrv.Shutdown(ctx)
 }
 
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("ok"))
+// Handler returns the HTTP handler serving every route of the API.
+func (s *Server) Handler() http.Handler {
+	return withRequestID(withTracing(s.authenticate(s.routes())))
+}
+
+func (s *Server) routes() *router {
+	rt := newRouter()
+	rt.handle(http.MethodGet, "/health", s.handleHealth)
+	rt.handle(http.MethodGet, "/livez", s.handleLivez)
+	rt.handle(http.MethodGet, "/readyz", s.handleReadyz)
+	rt.handle(http.MethodGet, "/readyz/details", s.admin(s.handleReadyzDetails))
+	// Most series are labelled by company, so only admins may scrape them.
+	rt.handle(http.MethodGet, "/metrics", s.admin(metrics.Handler().ServeHTTP))
+	rt.handle(http.MethodGet, "/openapi.json", s.handleOpenAPI)
+
+	rt.handle(http.MethodGet, "/sessions", s.handleList)
+	rt.handle(http.MethodGet, "/sessions/{company}", s.company(s.handleSession))
+	rt.handle(http.MethodPost, "/sessions/{company}/connect", s.company(s.handleConnect))
+	rt.handle(http.MethodPost, "/sessions/{company}/logout", s.company(s.handleLogout))
+	rt.handle(http.MethodDelete, "/sessions/{company}/logout", s.company(s.handleLogout))
+
+	rt.handle(http.MethodPost, "/messages", s.handleSend)
+	rt.handle(http.MethodGet, "/messages/{company}", s.company(s.handleMessages))
+	rt.handle(http.MethodGet, "/messages/{company}/search", s.company(s.handleSearch))
+	rt.handle(http.MethodGet, "/scheduled/{company}", s.company(s.handleListScheduled))
+	rt.handle(http.MethodGet, "/scheduled/{company}/{id}", s.company(s.handleGetScheduled))
+	rt.handle(http.MethodPatch, "/scheduled/{company}/{id}", s.company(s.handleReschedule))
+	rt.handle(http.MethodDelete, "/scheduled/{company}/{id}", s.company(s.handleCancelScheduled))
+	rt.handle(http.MethodGet, "/chats/{company}", s.company(s.handleChats))
+	rt.handle(http.MethodPost, "/contacts/{company}/check", s.company(s.handleCheck))
+	rt.handle(http.MethodPost, "/contacts/{company}/presence", s.company(s.handlePresence))
+
+	rt.handle(http.MethodGet, "/events", s.handleEvents)
+	rt.handle(http.MethodGet, "/events/ws", s.handleEventsWS)
+
+	rt.handle(http.MethodGet, "/webhooks/{company}", s.company(s.handleListWebhooks))
+	rt.handle(http.MethodPost, "/webhooks/{company}", s.company(s.handleCreateWebhook))
+	rt.handle(http.MethodDelete, "/webhooks/{company}/{id}", s.company(s.handleDeleteWebhook))
+	rt.handle(http.MethodPost, "/webhooks/{company}/{id}/enable", s.company(s.handleEnableWebhook))
+	rt.handle(http.MethodGet, "/webhooks/{company}/{id}/deliveries", s.company(s.handleDeliveries))
+
+	rt.handle(http.MethodGet, "/apikeys", s.admin(s.handleListKeys))
+	rt.handle(http.MethodPost, "/apikeys", s.admin(s.handleCreateKey))
+	rt.handle(http.MethodDelete, "/apikeys/{id}", s.admin(s.handleRevokeKey))
+	return rt
 }
 
 func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+	list, err := s.wa.ListSessions(r.Context())
+	if err != nil {
+		writeServiceError(w, r, err)
 		return
 	}
-	sess := s.wa.Sessions()
-	json.NewEncoder(w).Encode(map[string][]string{"sessions": sess})
+	p, _ := auth.FromContext(r.Context())
+	visible := make([]whatsapp.SessionStatus, 0, len(list))
+	for _, st := range list {
+		if p.CanAccess(st.CompanyID) {
+			visible = append(visible, st)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string][]whatsapp.SessionStatus{"sessions": visible})
 }
 
 func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
-	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/sessions/"), "/")
-	if len(parts) < 2 {
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-	id := parts[0]
-	action := parts[1]
-	switch action {
-	case "logout":
-		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
-		}
-		if err := s.wa.Logout(context.Background(), id); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	st, err := s.wa.Status(r.Context(), r.PathValue("company"))
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if err := s.wa.Logout(context.Background(), r.PathValue("company")); err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
+	qr, err := s.wa.Connect(r.Context(), r.PathValue("company"))
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	if qr == "" {
 		w.WriteHeader(http.StatusNoContent)
-	case "connect":
-		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
-		}
-		qr, err := s.wa.Connect(r.Context(), id)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		if qr == "" {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-		b64 := base64.StdEncoding.EncodeToString([]byte(qr))
-		json.NewEncoder(w).Encode(map[string]string{"qr": b64})
-	default:
-		w.WriteHeader(http.StatusNotFound)
+		return
 	}
+	b64 := base64.StdEncoding.EncodeToString([]byte(qr))
+	writeJSON(w, http.StatusOK, map[string]string{"qr": b64})
 }
 
 func (s *Server) handleSend(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+	var msg whatsapp.OutgoingMessage
+	if !decodeJSON(w, r, &msg) {
 		return
 	}
-	var msg whatsapp.OutgoingMessage
-	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
-		http.Error(w, "invalid json", http.StatusBadRequest)
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		msg.IdempotencyKey = key
+	}
+	if err := msg.Validate(); err != nil {
+		writeServiceError(w, r, err)
 		return
 	}
-	if err := s.wa.Send(r.Context(), &msg); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !authorize(w, r, msg.CompanyID) {
 		return
 	}
-	w.WriteHeader(http.StatusAccepted)
+	if msg.SendAt != nil && msg.SendAt.After(time.Now()) {
+		s.schedule(w, r, &msg)
+		return
+	}
+	res, err := s.wa.Send(r.Context(), &msg)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	status := http.StatusAccepted
+	if res.Duplicate {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, res)
+}
+
+// schedule stores a message sent with a future send_at for later.
+func (s *Server) schedule(w http.ResponseWriter, r *http.Request, msg *whatsapp.OutgoingMessage) {
+	m, err := s.wa.Schedule(r.Context(), msg)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	status := http.StatusCreated
+	if m.Duplicate {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, m)
+}
+
+func (s *Server) handleCheck(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Numbers []string `json:"numbers"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.Numbers) == 0 || len(req.Numbers) > 500 {
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, "numbers must hold between 1 and 500 entries")
+		return
+	}
+	results, err := s.wa.CheckNumbers(r.Context(), r.PathValue("company"), req.Numbers)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]whatsapp.NumberCheck{"results": results})
+}
+
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := messages.Filter{
+		Chat:   q.Get("chat"),
+		Sender: q.Get("sender"),
+		Type:   q.Get("type"),
+		Status: q.Get("status"),
+		Cursor: q.Get("cursor"),
+	}
+	var err error
+	if f.Since, err = parseTime(q.Get("since")); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "invalid since")
+		return
+	}
+	if f.Until, err = parseTime(q.Get("until")); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "invalid until")
+		return
+	}
+	if f.Limit, err = parseLimit(q.Get("limit")); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "invalid limit")
+		return
+	}
+	list, next, err := s.msgRepo.List(r.Context(), r.PathValue("company"), f)
+	if errors.Is(err, messages.ErrInvalidCursor) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"messages": list, "next_cursor": next})
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := strings.TrimSpace(q.Get("q"))
+	if query == "" {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "missing q")
+		return
+	}
+	limit, err := parseLimit(q.Get("limit"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "invalid limit")
+		return
+	}
+	results, next, err := s.msgRepo.Search(r.Context(), r.PathValue("company"), query, q.Get("chat"), q.Get("cursor"), limit)
+	if errors.Is(err, messages.ErrInvalidCursor) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"results": results, "next_cursor": next})
+}
+
+func (s *Server) handleChats(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "invalid limit")
+		return
+	}
+	chats, next, err := s.msgRepo.Chats(r.Context(), r.PathValue("company"), r.URL.Query().Get("cursor"), limit)
+	if errors.Is(err, messages.ErrInvalidCursor) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"chats": chats, "next_cursor": next})
+}
+
+func parseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+func parseLimit(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(v)
 }
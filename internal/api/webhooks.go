@@ -0,0 +1,98 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/example/wpp-wave-bot/internal/webhooks"
+)
+
+func (s *Server) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := s.hookRepo.List(r.Context(), r.PathValue("company"))
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]webhooks.Webhook{"webhooks": hooks})
+}
+
+func (s *Server) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	for _, event := range req.Events {
+		if !webhooks.ValidEvent(event) {
+			writeError(w, r, http.StatusBadRequest, codeValidationFailed, "unknown event "+strconv.Quote(event))
+			return
+		}
+	}
+	if err := webhooks.CheckTarget(r.Context(), req.URL); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, err.Error())
+		return
+	}
+	hook, err := s.hookRepo.Create(r.Context(), r.PathValue("company"), req.URL, req.Events)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, hook)
+}
+
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	s.writeWebhookResult(w, r, s.hookRepo.Delete(r.Context(), r.PathValue("company"), id))
+}
+
+func (s *Server) handleEnableWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	s.writeWebhookResult(w, r, s.hookRepo.Enable(r.Context(), r.PathValue("company"), id))
+}
+
+func (s *Server) handleDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	limit, err := parseLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "invalid limit")
+		return
+	}
+	list, err := s.hookRepo.Deliveries(r.Context(), r.PathValue("company"), id, limit)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]webhooks.Delivery{"deliveries": list})
+}
+
+func (s *Server) writeWebhookResult(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, webhooks.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, codeWebhookNotFound, err.Error())
+	case err != nil:
+		writeServiceError(w, r, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func webhookID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, codeWebhookNotFound, webhooks.ErrNotFound.Error())
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/example/wpp-wave-bot/internal/db"
+)
+
+// ErrInvalidKey is returned when an API key is unknown or revoked.
+var ErrInvalidKey = errors.New("invalid api key")
+
+// ErrKeyNotFound is returned when revoking a key that doesn't exist.
+var ErrKeyNotFound = errors.New("api key not found")
+
+// keyPrefix marks API keys so they are easy to spot in configs and logs.
+const keyPrefix = "wwb_"
+
+// APIKey describes a stored API key. The key itself is only known at creation
+// time; the database keeps its SHA-256 hash.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	CompanyID  string     `json:"company_id,omitempty"`
+	Admin      bool       `json:"admin"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Repository handles API key persistence.
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+// NewRepository creates a new Repository.
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+const keyColumns = `id, name, COALESCE(company_id, ''), admin, prefix, created_at, last_used_at, revoked_at`
+
+// Create generates a new key scoped to companyID, or to every company when
+// admin is set. The plain key is returned once and never stored.
+func (r *Repository) Create(ctx context.Context, name, companyID string, admin bool) (string, *APIKey, error) {
+	ctx = db.WithQueryName(ctx, "apikeys.create")
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	key := keyPrefix + hex.EncodeToString(secret)
+
+	var k APIKey
+	err := scanKey(r.db.QueryRow(ctx, `
+        INSERT INTO api_keys (name, company_id, admin, key_hash, prefix)
+        VALUES ($1, NULLIF($2, ''), $3, $4, $5)
+        RETURNING `+keyColumns,
+		name, companyID, admin, hashKey(key), key[:len(keyPrefix)+8],
+	), &k)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, &k, nil
+}
+
+// List returns every key, including revoked ones.
+func (r *Repository) List(ctx context.Context) ([]APIKey, error) {
+	ctx = db.WithQueryName(ctx, "apikeys.list")
+	rows, err := r.db.Query(ctx, `SELECT `+keyColumns+` FROM api_keys ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		if err := scanKey(rows, &k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// Revoke disables a key.
+func (r *Repository) Revoke(ctx context.Context, id int64) error {
+	ctx = db.WithQueryName(ctx, "apikeys.revoke")
+	tag, err := r.db.Exec(ctx, `UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves a plain key into the principal it grants.
+func (r *Repository) Authenticate(ctx context.Context, key string) (*Principal, error) {
+	ctx = db.WithQueryName(ctx, "apikeys.authenticate")
+	var k APIKey
+	err := scanKey(r.db.QueryRow(ctx, `
+        UPDATE api_keys SET last_used_at = now()
+        WHERE key_hash = $1 AND revoked_at IS NULL
+        RETURNING `+keyColumns,
+		hashKey(key),
+	), &k)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{
+		Subject:   "apikey:" + strconv.FormatInt(k.ID, 10),
+		CompanyID: k.CompanyID,
+		Admin:     k.Admin,
+	}, nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanKey(row scanner, k *APIKey) error {
+	return row.Scan(&k.ID, &k.Name, &k.CompanyID, &k.Admin, &k.Prefix, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt)
+}
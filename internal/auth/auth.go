@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"context"
+	"strings"
+)
+
+// Principal is the authenticated caller of the admin API. Admins can act on
+// every company, everyone else only on CompanyID.
+type Principal struct {
+	Subject   string
+	CompanyID string
+	Admin     bool
+	Roles     []string
+}
+
+// CanAccess reports whether the principal may act on the company.
+func (p *Principal) CanAccess(companyID string) bool {
+	return p.Admin || (p.CompanyID != "" && p.CompanyID == companyID)
+}
+
+type ctxKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// FromContext returns the principal stored in ctx, if any.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(ctxKey{}).(*Principal)
+	return p, ok
+}
+
+// KeyStore resolves API keys to the principal they grant, failing with
+// ErrInvalidKey for unknown or revoked keys. Repository implements it.
+type KeyStore interface {
+	Authenticate(ctx context.Context, key string) (*Principal, error)
+}
+
+// Authenticator resolves the credentials of a request, an API key or a JWT,
+// to a principal.
+type Authenticator struct {
+	keys KeyStore
+	jwt  *JWTVerifier
+}
+
+// NewAuthenticator creates an Authenticator. Bearer JWTs are only accepted
+// when jwt is not nil.
+func NewAuthenticator(keys KeyStore, jwt *JWTVerifier) *Authenticator {
+	return &Authenticator{keys: keys, jwt: jwt}
+}
+
+// Authenticate returns the principal of token, failing with ErrInvalidKey or
+// ErrInvalidToken when it isn't valid.
+func (a *Authenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	if a.jwt != nil && strings.Count(token, ".") == 2 {
+		return a.jwt.Verify(ctx, token)
+	}
+	return a.keys.Authenticate(ctx, token)
+}
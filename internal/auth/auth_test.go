@@ -0,0 +1,25 @@
+package auth
+
+import "testing"
+
+func TestCanAccess(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       Principal
+		company string
+		want    bool
+	}{
+		{"own company", Principal{CompanyID: "empresa-123"}, "empresa-123", true},
+		{"other company", Principal{CompanyID: "empresa-123"}, "empresa-456", false},
+		{"admin", Principal{Admin: true}, "empresa-456", true},
+		{"unscoped", Principal{}, "empresa-123", false},
+		{"unscoped and empty company", Principal{}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.CanAccess(tt.company); got != tt.want {
+				t.Errorf("CanAccess(%q) = %v, want %v", tt.company, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidToken is returned when a bearer JWT fails validation.
+var ErrInvalidToken = errors.New("invalid token")
+
+// JWTConfig configures validation of bearer JWTs issued by an identity
+// provider. Exactly one of JWKSURL and JWKSFile must be set.
+type JWTConfig struct {
+	JWKSURL  string
+	JWKSFile string
+	// Issuer and Audience are checked when set.
+	Issuer   string
+	Audience string
+	// CompanyClaim and RolesClaim name the claims holding the company ID and
+	// roles. Nested claims use dots, e.g. "realm_access.roles".
+	CompanyClaim string
+	RolesClaim   string
+	// AdminRole grants admin scope to tokens carrying it.
+	AdminRole string
+	// RefreshInterval is how often keys are reloaded from JWKSURL.
+	RefreshInterval time.Duration
+}
+
+// JWTVerifier validates JWTs against a JSON Web Key Set.
+type JWTVerifier struct {
+	cfg    JWTConfig
+	client *http.Client
+
+	mu       sync.RWMutex
+	keys     map[string]crypto.PublicKey
+	loadedAt time.Time
+}
+
+// NewJWTVerifier loads the key set and returns a verifier using it.
+func NewJWTVerifier(ctx context.Context, cfg JWTConfig) (*JWTVerifier, error) {
+	if (cfg.JWKSURL == "") == (cfg.JWKSFile == "") {
+		return nil, fmt.Errorf("exactly one of jwks url or file is required")
+	}
+	if cfg.CompanyClaim == "" {
+		cfg.CompanyClaim = "company_id"
+	}
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = "roles"
+	}
+	if cfg.AdminRole == "" {
+		cfg.AdminRole = "admin"
+	}
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = time.Hour
+	}
+	v := &JWTVerifier{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+	if err := v.load(ctx); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Verify validates a token and returns the principal it grants. Tokens
+// without a company claim are only accepted when they carry the admin role.
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (*Principal, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if v.cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.cfg.Issuer))
+	}
+	if v.cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(v.cfg.Audience))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.key(ctx, kid)
+	}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	sub, _ := claims.GetSubject()
+	company, _ := lookupClaim(claims, v.cfg.CompanyClaim).(string)
+	roles := stringList(lookupClaim(claims, v.cfg.RolesClaim))
+	p := &Principal{Subject: "jwt:" + sub, CompanyID: company, Roles: roles}
+	for _, r := range roles {
+		if r == v.cfg.AdminRole {
+			p.Admin = true
+		}
+	}
+	if p.CompanyID == "" && !p.Admin {
+		return nil, fmt.Errorf("%w: missing %s claim", ErrInvalidToken, v.cfg.CompanyClaim)
+	}
+	return p, nil
+}
+
+// key returns the public key with the given ID, reloading the key set when
+// it is unknown or stale. Reloads for unknown IDs are limited to one a minute.
+func (v *JWTVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.RLock()
+	k, ok := v.keys[kid]
+	if !ok && kid == "" && len(v.keys) == 1 {
+		for _, only := range v.keys {
+			k, ok = only, true
+		}
+	}
+	age := time.Since(v.loadedAt)
+	v.mu.RUnlock()
+
+	if v.cfg.JWKSURL != "" && (age > v.cfg.RefreshInterval || (!ok && age > time.Minute)) {
+		if err := v.load(ctx); err != nil {
+			return nil, err
+		}
+		return v.key(ctx, kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return k, nil
+}
+
+func (v *JWTVerifier) load(ctx context.Context) error {
+	var data []byte
+	var err error
+	if v.cfg.JWKSFile != "" {
+		data, err = os.ReadFile(v.cfg.JWKSFile)
+	} else {
+		data, err = v.fetch(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("load jwks: %w", err)
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("load jwks: %w", err)
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.loadedAt = time.Now()
+	v.mu.Unlock()
+	return nil
+}
+
+func (v *JWTVerifier) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.cfg.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS decodes the signing keys of a JSON Web Key Set keyed by key ID.
+// RSA, EC (P-256, P-384, P-521) and Ed25519 keys are supported.
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = pub
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// lookupClaim resolves a dotted claim path inside nested objects.
+func lookupClaim(claims map[string]any, path string) any {
+	var cur any = claims
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = m[part]
+	}
+	return cur
+}
+
+// stringList accepts both JSON arrays and space separated strings, the two
+// shapes identity providers use for role and scope claims.
+func stringList(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
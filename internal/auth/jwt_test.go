@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer   = "https://idp.example.com/"
+	testAudience = "wpp-wave-bot"
+)
+
+// testKeys are the signing keys served in the local JWKS of the tests.
+type testKeys struct {
+	rsa     *rsa.PrivateKey
+	ec      *ecdsa.PrivateKey
+	ed      ed25519.PrivateKey
+	unknown *rsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKeys{rsa: rsaKey, ec: ecKey, ed: edKey, unknown: unknown}
+}
+
+// writeJWKS writes the public keys to a JWKS file and returns its path.
+func (k *testKeys) writeJWKS(t *testing.T) string {
+	t.Helper()
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	set := map[string]any{"keys": []map[string]string{
+		{"kid": "rsa", "kty": "RSA", "use": "sig", "n": b64(k.rsa.N.Bytes()), "e": b64(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{"kid": "ec", "kty": "EC", "crv": "P-256", "x": b64(k.ec.X.Bytes()), "y": b64(k.ec.Y.Bytes())},
+		{"kid": "ed", "kty": "OKP", "crv": "Ed25519", "x": b64(k.ed.Public().(ed25519.PublicKey))},
+		{"kid": "enc", "kty": "RSA", "use": "enc", "n": b64(k.unknown.N.Bytes()), "e": "AQAB"},
+	}}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestVerifier(t *testing.T, keys *testKeys, cfg JWTConfig) *JWTVerifier {
+	t.Helper()
+	cfg.JWKSFile = keys.writeJWKS(t)
+	v, err := NewJWTVerifier(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// claims returns valid claims for the company, with overrides applied and
+// nil values removed.
+func claims(company string, overrides jwt.MapClaims) jwt.MapClaims {
+	c := jwt.MapClaims{
+		"sub":        "user-1",
+		"iss":        testIssuer,
+		"aud":        testAudience,
+		"exp":        time.Now().Add(time.Hour).Unix(),
+		"company_id": company,
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+func TestJWTVerify(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newTestVerifier(t, keys, JWTConfig{Issuer: testIssuer, Audience: testAudience})
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"rsa", sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, claims("empresa-123", nil))},
+		{"ecdsa", sign(t, jwt.SigningMethodES256, "ec", keys.ec, claims("empresa-123", nil))},
+		{"ed25519", sign(t, jwt.SigningMethodEdDSA, "ed", keys.ed, claims("empresa-123", nil))},
+		{"within leeway", sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, claims("empresa-123", jwt.MapClaims{"exp": time.Now().Add(-10 * time.Second).Unix()}))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := v.Verify(ctx, tt.token)
+			if err != nil {
+				t.Fatalf("Verify error: %v", err)
+			}
+			if p.Subject != "jwt:user-1" || p.CompanyID != "empresa-123" || p.Admin {
+				t.Errorf("Verify = %+v", p)
+			}
+			if !p.CanAccess("empresa-123") {
+				t.Error("token can't access its own company")
+			}
+			if p.CanAccess("empresa-456") {
+				t.Error("token can access another company")
+			}
+		})
+	}
+}
+
+func TestJWTVerifyRejects(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newTestVerifier(t, keys, JWTConfig{Issuer: testIssuer, Audience: testAudience})
+	ctx := context.Background()
+
+	rsaPub, err := x509.MarshalPKIXPublicKey(&keys.rsa.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	none := sign(t, jwt.SigningMethodNone, "rsa", jwt.UnsafeAllowNoneSignatureType, claims("empresa-123", nil))
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"expired", sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, claims("empresa-123", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}))},
+		{"no expiry", sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, claims("empresa-123", jwt.MapClaims{"exp": nil}))},
+		{"not yet valid", sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, claims("empresa-123", jwt.MapClaims{"nbf": time.Now().Add(time.Hour).Unix()}))},
+		{"wrong issuer", sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, claims("empresa-123", jwt.MapClaims{"iss": "https://evil.example.com/"}))},
+		{"no issuer", sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, claims("empresa-123", jwt.MapClaims{"iss": nil}))},
+		{"wrong audience", sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, claims("empresa-123", jwt.MapClaims{"aud": "other-service"}))},
+		{"no audience", sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, claims("empresa-123", jwt.MapClaims{"aud": nil}))},
+		{"alg none", none},
+		{"alg none without signature", none[:len(none)-len(none[lastDot(none):])] + "."},
+		{"hmac with the rsa public key", sign(t, jwt.SigningMethodHS256, "rsa", rsaPub, claims("empresa-123", nil))},
+		{"unknown key id", sign(t, jwt.SigningMethodRS256, "other", keys.unknown, claims("empresa-123", nil))},
+		{"encryption key", sign(t, jwt.SigningMethodRS256, "enc", keys.unknown, claims("empresa-123", nil))},
+		{"signed by another key", sign(t, jwt.SigningMethodRS256, "rsa", keys.unknown, claims("empresa-123", nil))},
+		{"key of another type", sign(t, jwt.SigningMethodES256, "rsa", keys.ec, claims("empresa-123", nil))},
+		{"no company", sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, claims("", nil))},
+		{"malformed", "not.a.jwt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := v.Verify(ctx, tt.token)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify = %+v, %v, want ErrInvalidToken", p, err)
+			}
+		})
+	}
+}
+
+func lastDot(s string) int {
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] == '.' {
+			return i
+		}
+	}
+	return 0
+}
+
+func TestJWTVerifyRoles(t *testing.T) {
+	keys := newTestKeys(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		cfg       JWTConfig
+		claims    jwt.MapClaims
+		admin     bool
+		company   string
+		wantError bool
+	}{
+		{
+			name:   "admin role without company",
+			claims: claims("", jwt.MapClaims{"company_id": nil, "roles": []string{"viewer", "admin"}}),
+			admin:  true,
+		},
+		{
+			name:    "admin role with company",
+			claims:  claims("empresa-123", jwt.MapClaims{"roles": "agent admin"}),
+			admin:   true,
+			company: "empresa-123",
+		},
+		{
+			name:    "other roles",
+			claims:  claims("empresa-123", jwt.MapClaims{"roles": []string{"agent"}}),
+			company: "empresa-123",
+		},
+		{
+			name:      "other roles without company",
+			claims:    claims("", jwt.MapClaims{"company_id": nil, "roles": []string{"agent"}}),
+			wantError: true,
+		},
+		{
+			name:   "custom nested claims",
+			cfg:    JWTConfig{CompanyClaim: "tenant.id", RolesClaim: "realm_access.roles", AdminRole: "bot-admin"},
+			claims: claims("", jwt.MapClaims{"company_id": nil, "realm_access": map[string]any{"roles": []string{"bot-admin"}}}),
+			admin:  true,
+		},
+		{
+			name:    "custom company claim",
+			cfg:     JWTConfig{CompanyClaim: "tenant.id"},
+			claims:  claims("", jwt.MapClaims{"company_id": "ignored", "tenant": map[string]any{"id": "empresa-456"}}),
+			company: "empresa-456",
+		},
+		{
+			name:   "admin role of another claim",
+			cfg:    JWTConfig{RolesClaim: "realm_access.roles"},
+			claims: claims("", jwt.MapClaims{"company_id": nil, "roles": []string{"admin"}}),
+			// roles is not the configured claim
+			wantError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newTestVerifier(t, keys, tt.cfg)
+			p, err := v.Verify(ctx, sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, tt.claims))
+			if tt.wantError {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("Verify = %+v, %v, want ErrInvalidToken", p, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify error: %v", err)
+			}
+			if p.Admin != tt.admin || p.CompanyID != tt.company {
+				t.Errorf("Verify = %+v, want admin %v and company %q", p, tt.admin, tt.company)
+			}
+			if p.CanAccess("empresa-789") != tt.admin {
+				t.Errorf("CanAccess(another company) = %v, want %v", !tt.admin, tt.admin)
+			}
+		})
+	}
+}
+
+func TestNewJWTVerifierRequiresOneSource(t *testing.T) {
+	ctx := context.Background()
+	if _, err := NewJWTVerifier(ctx, JWTConfig{}); err == nil {
+		t.Error("verifier created without a key set")
+	}
+	if _, err := NewJWTVerifier(ctx, JWTConfig{JWKSURL: "https://idp.example.com/jwks", JWKSFile: "jwks.json"}); err == nil {
+		t.Error("verifier created with both key sources")
+	}
+	if _, err := NewJWTVerifier(ctx, JWTConfig{JWKSFile: filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+		t.Error("verifier created from a missing file")
+	}
+}
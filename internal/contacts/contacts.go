@@ -2,8 +2,11 @@ package contacts
 
 import (
 	"context"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/example/wpp-wave-bot/internal/db"
 )
 
 // Repository provides helpers to persist contacts.
@@ -18,6 +21,7 @@ func NewRepository(db *pgxpool.Pool) *Repository {
 
 // Upsert inserts or updates a contact.
 func (r *Repository) Upsert(ctx context.Context, companyID, jid, name, phone, avatarURL string) error {
+	ctx = db.WithQueryName(ctx, "contacts.upsert")
 	_, err := r.db.Exec(ctx, `
         INSERT INTO contacts (jid, company_id, name, phone, avatar_url, last_seen)
         VALUES ($1, $2, $3, $4, $5, now())
@@ -30,3 +34,50 @@ func (r *Repository) Upsert(ctx context.Context, companyID, jid, name, phone, av
     `, jid, companyID, name, phone, avatarURL)
 	return err
 }
+
+// Check is the cached result of a WhatsApp registration lookup.
+type Check struct {
+	Phone      string    `json:"phone"`
+	JID        string    `json:"jid,omitempty"`
+	Registered bool      `json:"registered"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+// Checks returns cached registration lookups for the given phones that are
+// newer than maxAge, keyed by phone.
+func (r *Repository) Checks(ctx context.Context, companyID string, phones []string, maxAge time.Duration) (map[string]Check, error) {
+	ctx = db.WithQueryName(ctx, "contacts.checks")
+	rows, err := r.db.Query(ctx, `
+        SELECT phone, COALESCE(jid, ''), registered, checked_at
+        FROM contact_checks
+        WHERE company_id = $1 AND phone = ANY($2) AND checked_at > $3
+    `, companyID, phones, time.Now().Add(-maxAge))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checks := make(map[string]Check)
+	for rows.Next() {
+		var c Check
+		if err := rows.Scan(&c.Phone, &c.JID, &c.Registered, &c.CheckedAt); err != nil {
+			return nil, err
+		}
+		checks[c.Phone] = c
+	}
+	return checks, rows.Err()
+}
+
+// SaveCheck stores the result of a registration lookup.
+func (r *Repository) SaveCheck(ctx context.Context, companyID string, c Check) error {
+	ctx = db.WithQueryName(ctx, "contacts.save_check")
+	_, err := r.db.Exec(ctx, `
+        INSERT INTO contact_checks (company_id, phone, jid, registered, checked_at)
+        VALUES ($1, $2, NULLIF($3, ''), $4, $5)
+        ON CONFLICT (company_id, phone) DO UPDATE
+            SET jid = EXCLUDED.jid,
+                registered = EXCLUDED.registered,
+                checked_at = EXCLUDED.checked_at
+    `, companyID, c.Phone, c.JID, c.Registered, c.CheckedAt)
+	return err
+}
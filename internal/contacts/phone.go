@@ -0,0 +1,60 @@
+package contacts
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+// ErrInvalidPhone is returned when a phone number cannot be normalized.
+var ErrInvalidPhone = errors.New("invalid phone number")
+
+// NormalizePhone converts a phone number in any common format into E.164
+// digits without the leading plus sign (e.g. "5511999999999"). Numbers without
+// an international prefix are assumed to belong to defaultCountry, a calling
+// code such as "55", unless they already start with it followed by a national
+// number of the right length for that country.
+func NormalizePhone(raw, defaultCountry string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	international := strings.HasPrefix(raw, "+")
+
+	var b strings.Builder
+	for _, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' || r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+			// formatting characters are dropped
+		default:
+			return "", ErrInvalidPhone
+		}
+	}
+	digits := b.String()
+
+	if strings.HasPrefix(digits, "00") {
+		digits = strings.TrimPrefix(digits, "00")
+		international = true
+	}
+
+	region := "ZZ"
+	if international {
+		digits = "+" + digits
+	} else {
+		code, err := strconv.Atoi(defaultCountry)
+		if err != nil {
+			return "", ErrInvalidPhone
+		}
+		region = phonenumbers.GetRegionCodeForCountryCode(code)
+		if region == "ZZ" {
+			return "", ErrInvalidPhone
+		}
+	}
+
+	num, err := phonenumbers.Parse(digits, region)
+	if err != nil || !phonenumbers.IsPossibleNumber(num) {
+		return "", ErrInvalidPhone
+	}
+	return strings.TrimPrefix(phonenumbers.Format(num, phonenumbers.E164), "+"), nil
+}
@@ -0,0 +1,60 @@
+package contacts
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		name, raw, country, want string
+	}{
+		{"br mobile with country code", "5511999999999", "55", "5511999999999"},
+		{"br mobile e164", "+55 (11) 99999-9999", "55", "5511999999999"},
+		{"br mobile national", "11999999999", "55", "5511999999999"},
+		{"br landline national", "(11) 3333-4444", "55", "551133334444"},
+		{"br trunk prefix", "011999999999", "55", "5511999999999"},
+		{"br area code 55", "55999999999", "55", "5555999999999"},
+		{"international 00 prefix", "00 55 11 99999 9999", "1", "5511999999999"},
+		{"us national", "(202) 555-1234", "1", "12025551234"},
+		{"us with country code", "12025551234", "1", "12025551234"},
+		{"us e164", "+1 202-555-1234", "55", "12025551234"},
+		{"uk national", "07911 123456", "44", "447911123456"},
+		{"uk with country code", "447911123456", "44", "447911123456"},
+		{"de national", "030 123456", "49", "4930123456"},
+		{"pt with country code", "351912345678", "351", "351912345678"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizePhone(tt.raw, tt.country)
+			if err != nil {
+				t.Fatalf("NormalizePhone(%q, %q) error: %v", tt.raw, tt.country, err)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizePhone(%q, %q) = %q, want %q", tt.raw, tt.country, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizePhoneInvalid(t *testing.T) {
+	tests := []struct {
+		name, raw, country string
+	}{
+		{"empty", "", "55"},
+		{"letters", "11 9999-abcd", "55"},
+		{"too short", "1234", "55"},
+		{"too long", "+55 11 99999 9999 9999", "55"},
+		{"no default country", "11999999999", ""},
+		{"unknown default country", "11999999999", "999"},
+		{"unknown calling code", "+999 1234 5678", "55"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizePhone(tt.raw, tt.country)
+			if !errors.Is(err, ErrInvalidPhone) {
+				t.Errorf("NormalizePhone(%q, %q) = %q, %v, want ErrInvalidPhone", tt.raw, tt.country, got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS contact_checks (
+    company_id TEXT NOT NULL,
+    phone TEXT NOT NULL,
+    jid TEXT,
+    registered BOOLEAN NOT NULL,
+    checked_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    PRIMARY KEY (company_id, phone)
+);
@@ -0,0 +1,14 @@
+ALTER TABLE messages
+    ADD COLUMN from_me BOOLEAN NOT NULL DEFAULT false;
+-- Only outgoing messages were ever stored as 'sent', while receipts also
+-- moved incoming ones to 'delivered' and 'read'. Those are ours when their
+-- sender is the session's own number, stored as a JID in sessions.data.
+UPDATE messages m SET from_me = true
+WHERE m.status = 'sent'
+   OR EXISTS (
+       SELECT 1 FROM sessions s
+       WHERE s.company_id = m.company_id
+         AND split_part(split_part(split_part(m.sender, '@', 1), ':', 1), '.', 1)
+             = split_part(split_part(split_part(convert_from(s.data, 'UTF8'), '@', 1), ':', 1), '.', 1)
+   );
+CREATE INDEX IF NOT EXISTS messages_company_chat_idx ON messages(company_id, receiver, id);
@@ -0,0 +1,11 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+ALTER TABLE messages
+    ADD COLUMN search_text TEXT GENERATED ALWAYS AS (
+        COALESCE(content, '') || ' ' ||
+        COALESCE(payload #>> '{imageMessage,caption}', '') || ' ' ||
+        COALESCE(payload #>> '{videoMessage,caption}', '') || ' ' ||
+        COALESCE(payload #>> '{documentMessage,caption}', '') || ' ' ||
+        COALESCE(payload #>> '{documentMessage,fileName}', '')
+    ) STORED;
+CREATE INDEX IF NOT EXISTS messages_search_fts_idx ON messages USING GIN (to_tsvector('portuguese', search_text));
+CREATE INDEX IF NOT EXISTS messages_search_trgm_idx ON messages USING GIN (search_text gin_trgm_ops);
@@ -0,0 +1,4 @@
+ALTER TABLE messages
+    ADD COLUMN idempotency_key TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS messages_idempotency_key_idx
+    ON messages(company_id, idempotency_key) WHERE idempotency_key IS NOT NULL;
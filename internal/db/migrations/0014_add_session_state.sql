@@ -0,0 +1,9 @@
+ALTER TABLE sessions
+    ADD COLUMN IF NOT EXISTS push_name TEXT,
+    ADD COLUMN IF NOT EXISTS platform TEXT,
+    ADD COLUMN IF NOT EXISTS paired_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS connected_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS disconnected_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS last_error TEXT;
+
+UPDATE sessions SET paired_at = created_at WHERE paired_at IS NULL;
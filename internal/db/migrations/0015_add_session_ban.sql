@@ -0,0 +1,2 @@
+ALTER TABLE sessions
+    ADD COLUMN IF NOT EXISTS banned_until TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS session_leases (
+    company_id TEXT PRIMARY KEY,
+    owner TEXT NOT NULL,
+    acquired_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS session_leases_owner_idx ON session_leases(owner);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS scheduled_messages (
+    id SERIAL PRIMARY KEY,
+    company_id TEXT NOT NULL,
+    send_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'scheduled',
+    idempotency_key TEXT,
+    dispatched_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS scheduled_messages_due_idx ON scheduled_messages(send_at) WHERE status = 'scheduled';
+CREATE INDEX IF NOT EXISTS scheduled_messages_company_idx ON scheduled_messages(company_id, send_at);
+CREATE UNIQUE INDEX IF NOT EXISTS scheduled_messages_idempotency_idx
+    ON scheduled_messages(company_id, idempotency_key) WHERE idempotency_key IS NOT NULL;
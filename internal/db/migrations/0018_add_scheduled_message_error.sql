@@ -0,0 +1,2 @@
+ALTER TABLE scheduled_messages
+    ADD COLUMN IF NOT EXISTS error TEXT;
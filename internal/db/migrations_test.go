@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// migrateTo connects to TEST_DATABASE_URL in a schema of its own, dropped
+// when the test ends, and applies the migrations up to and including last.
+func migrateTo(t *testing.T, last string) *pgx.Conn {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("migrations_test_%d", time.Now().UnixNano())
+	if _, err := conn.Exec(ctx, "CREATE SCHEMA "+schema+"; SET search_path TO "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+		conn.Close(context.Background())
+	})
+
+	files, err := filepath.Glob("migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		if filepath.Base(f)[:len(last)] > last {
+			break
+		}
+		apply(t, conn, f)
+	}
+	return conn
+}
+
+func apply(t *testing.T, conn *pgx.Conn, file string) {
+	t.Helper()
+	sql, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(context.Background(), string(sql)); err != nil {
+		t.Fatalf("%s: %v", file, err)
+	}
+}
+
+func TestMessageFromMeBackfill(t *testing.T) {
+	conn := migrateTo(t, "0007")
+	ctx := context.Background()
+
+	_, err := conn.Exec(ctx, `
+        INSERT INTO sessions (company_id, data) VALUES
+            ('empresa-123', convert_to('5511900000000:12@s.whatsapp.net', 'UTF8'));
+        INSERT INTO messages (company_id, msg_id, sender, receiver, type, status) VALUES
+            ('empresa-123', 'out-sent', '5511900000000:12@s.whatsapp.net', '5511988887777@s.whatsapp.net', 'text', 'sent'),
+            ('empresa-123', 'out-read', '5511900000000:12@s.whatsapp.net', '5511988887777@s.whatsapp.net', 'text', 'read'),
+            ('empresa-123', 'out-phone', '5511900000000@s.whatsapp.net', '5511988887777@s.whatsapp.net', 'text', 'delivered'),
+            ('empresa-123', 'in-received', '5511988887777@s.whatsapp.net', '5511988887777@s.whatsapp.net', 'text', 'received'),
+            ('empresa-123', 'in-delivered', '5511988887777@s.whatsapp.net', '5511988887777@s.whatsapp.net', 'text', 'delivered'),
+            ('empresa-123', 'in-read', '5511988887777:3@s.whatsapp.net', '120363000000000000@g.us', 'text', 'read'),
+            ('empresa-456', 'gone-sent', '5511911111111:2@s.whatsapp.net', '5511988887777@s.whatsapp.net', 'text', 'sent'),
+            ('empresa-456', 'gone-read', '5511988887777@s.whatsapp.net', '5511988887777@s.whatsapp.net', 'text', 'read');
+    `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply(t, conn, "migrations/0008_add_message_from_me.sql")
+
+	rows, err := conn.Query(ctx, `SELECT msg_id, from_me FROM messages`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (string, error) {
+		var id string
+		var fromMe bool
+		err := row.Scan(&id, &fromMe)
+		return fmt.Sprintf("%s=%v", id, fromMe), err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	want := []string{
+		"gone-read=false", "gone-sent=true",
+		"in-delivered=false", "in-read=false", "in-received=false",
+		"out-phone=true", "out-read=true", "out-sent=true",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("from_me = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/example/wpp-wave-bot/internal/metrics"
+	"github.com/example/wpp-wave-bot/internal/tracing"
+)
+
+type queryStartKey struct{}
+
+type queryNameKey struct{}
+
+type queryStart struct {
+	at    time.Time
+	query string
+	span  trace.Span
+}
+
+// WithQueryName returns a copy of ctx naming the queries run with it, such as
+// "messages.save", in metrics and spans. Repositories name every method's
+// queries; unnamed ones are reported by their statement, e.g. "select".
+func WithQueryName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, queryNameKey{}, name)
+}
+
+// queryName returns the name ctx gives to sql.
+func queryName(ctx context.Context, sql string) string {
+	if name, ok := ctx.Value(queryNameKey{}).(string); ok {
+		return name
+	}
+	return operation(sql)
+}
+
+// queryTracer records the latency of every query in
+// wpp_db_query_duration_seconds and wraps it in a span.
+type queryTracer struct{}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	query := queryName(ctx, data.SQL)
+	ctx, span := tracing.Start(ctx, "db "+query, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation", operation(data.SQL)),
+		attribute.String("db.statement", strings.TrimSpace(data.SQL)),
+	))
+	return context.WithValue(ctx, queryStartKey{}, queryStart{at: time.Now(), query: query, span: span})
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	if start, ok := ctx.Value(queryStartKey{}).(queryStart); ok {
+		metrics.DBQueryDuration.WithLabelValues(start.query).Observe(time.Since(start.at).Seconds())
+		tracing.End(start.span, data.Err)
+	}
+}
+
+// operation returns the statement of a query, e.g. "select".
+func operation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "other"
+	}
+	switch op := strings.ToLower(fields[0]); op {
+	case "select", "insert", "update", "delete":
+		return op
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestQueryName(t *testing.T) {
+	named := WithQueryName(context.Background(), "messages.save")
+	tests := []struct {
+		name string
+		ctx  context.Context
+		sql  string
+		want string
+	}{
+		{"named", named, `INSERT INTO messages (company_id) VALUES ($1)`, "messages.save"},
+		{"renamed", WithQueryName(named, "messages.list"), `SELECT id FROM messages`, "messages.list"},
+		{"select", context.Background(), "\n        SELECT id FROM messages", "select"},
+		{"update", context.Background(), `update messages SET status = $1`, "update"},
+		{"other", context.Background(), `WITH due AS (SELECT 1) SELECT * FROM due`, "other"},
+		{"empty", context.Background(), ``, "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := queryName(tt.ctx, tt.sql); got != tt.want {
+				t.Errorf("queryName = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package events
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Event is a service event as published to the queues and webhooks.
+type Event struct {
+	Name      string          `json:"event"`
+	CompanyID string          `json:"company_id"`
+	Payload   json.RawMessage `json:"payload"`
+	Time      time.Time       `json:"time"`
+}
+
+// bufferSize is how many events a subscriber may lag behind before new
+// events are dropped for it.
+const bufferSize = 256
+
+type subscriber struct {
+	companyID string
+	ch        chan Event
+}
+
+// Hub fans events out to in-process subscribers such as streaming API
+// clients.
+type Hub struct {
+	mu   sync.RWMutex
+	subs map[*subscriber]struct{}
+}
+
+// NewHub creates an empty Hub.
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*subscriber]struct{})}
+}
+
+// Subscribe returns a channel receiving the events of companyID, or of every
+// company when companyID is empty. The returned function unsubscribes and
+// closes the channel.
+func (h *Hub) Subscribe(companyID string) (<-chan Event, func()) {
+	sub := &subscriber{companyID: companyID, ch: make(chan Event, bufferSize)}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subs, sub)
+			h.mu.Unlock()
+			close(sub.ch)
+		})
+	}
+}
+
+// Publish sends an event happening now to every matching subscriber, like
+// Deliver, and returns it.
+func (h *Hub) Publish(name, companyID string, payload []byte) Event {
+	evt := Event{Name: name, CompanyID: companyID, Payload: payload, Time: time.Now().UTC()}
+	h.Deliver(evt)
+	return evt
+}
+
+// Deliver sends evt to every matching subscriber without blocking.
+// Subscribers whose buffer is full miss the event.
+func (h *Hub) Deliver(evt Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subs {
+		if sub.companyID != "" && sub.companyID != evt.CompanyID {
+			continue
+		}
+		select {
+		case sub.ch <- evt:
+		default:
+			log.Warn().Str("company_id", evt.CompanyID).Str("event", evt.Name).Msg("event subscriber lagging, dropping event")
+		}
+	}
+}
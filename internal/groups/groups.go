@@ -4,6 +4,8 @@ import (
 	"context"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/example/wpp-wave-bot/internal/db"
 )
 
 // Repository handles group persistence.
@@ -18,6 +20,7 @@ func NewRepository(db *pgxpool.Pool) *Repository {
 
 // Upsert inserts or updates a group record.
 func (r *Repository) Upsert(ctx context.Context, companyID, jid, name string) error {
+	ctx = db.WithQueryName(ctx, "groups.upsert")
 	_, err := r.db.Exec(ctx, `
         INSERT INTO groups (jid, company_id, name)
         VALUES ($1, $2, $3)
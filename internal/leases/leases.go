@@ -0,0 +1,142 @@
+package leases
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/example/wpp-wave-bot/internal/db"
+)
+
+// Repository stores which instance owns the session of each company. An
+// owner keeps its leases by renewing them before they expire; expired leases
+// can be taken over by any instance.
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+// NewRepository creates a new Repository.
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+// Acquire takes the lease of a company for owner for ttl, unless another
+// owner holds a lease that hasn't expired. It reports whether owner holds
+// the lease now.
+func (r *Repository) Acquire(ctx context.Context, companyID, owner string, ttl time.Duration) (bool, error) {
+	ctx = db.WithQueryName(ctx, "leases.acquire")
+	var got string
+	err := r.db.QueryRow(ctx, `
+        INSERT INTO session_leases (company_id, owner, expires_at)
+        VALUES ($1, $2, now() + make_interval(secs => $3))
+        ON CONFLICT (company_id) DO UPDATE
+            SET owner = EXCLUDED.owner,
+                acquired_at = CASE WHEN session_leases.owner = EXCLUDED.owner
+                    THEN session_leases.acquired_at ELSE now() END,
+                expires_at = EXCLUDED.expires_at
+            WHERE session_leases.owner = EXCLUDED.owner OR session_leases.expires_at < now()
+        RETURNING owner
+    `, companyID, owner, ttl.Seconds()).Scan(&got)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return got == owner, nil
+}
+
+// Renew extends the leases owner holds on the given companies by ttl and
+// returns the companies it still owns.
+func (r *Repository) Renew(ctx context.Context, owner string, companyIDs []string, ttl time.Duration) ([]string, error) {
+	ctx = db.WithQueryName(ctx, "leases.renew")
+	rows, err := r.db.Query(ctx, `
+        UPDATE session_leases
+        SET expires_at = now() + make_interval(secs => $3)
+        WHERE owner = $1 AND company_id = ANY($2)
+        RETURNING company_id
+    `, owner, companyIDs, ttl.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+// Release gives up the lease of a company if owner holds it.
+func (r *Repository) Release(ctx context.Context, companyID, owner string) error {
+	ctx = db.WithQueryName(ctx, "leases.release")
+	_, err := r.db.Exec(ctx, `DELETE FROM session_leases WHERE company_id = $1 AND owner = $2`, companyID, owner)
+	return err
+}
+
+// ReleaseAll gives up every lease owner holds.
+func (r *Repository) ReleaseAll(ctx context.Context, owner string) error {
+	ctx = db.WithQueryName(ctx, "leases.release_all")
+	_, err := r.db.Exec(ctx, `DELETE FROM session_leases WHERE owner = $1`, owner)
+	return err
+}
+
+// Owner returns the owner of the company's lease, or "" when nobody holds
+// a lease that hasn't expired.
+func (r *Repository) Owner(ctx context.Context, companyID string) (string, error) {
+	ctx = db.WithQueryName(ctx, "leases.owner")
+	var owner string
+	err := r.db.QueryRow(ctx, `SELECT owner FROM session_leases WHERE company_id = $1 AND expires_at > now()`, companyID).Scan(&owner)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return owner, err
+}
+
+// Owners returns the owner of every lease that hasn't expired, by company.
+func (r *Repository) Owners(ctx context.Context) (map[string]string, error) {
+	ctx = db.WithQueryName(ctx, "leases.owners")
+	rows, err := r.db.Query(ctx, `SELECT company_id, owner FROM session_leases WHERE expires_at > now()`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	owners := make(map[string]string)
+	for rows.Next() {
+		var companyID, owner string
+		if err := rows.Scan(&companyID, &owner); err != nil {
+			return nil, err
+		}
+		owners[companyID] = owner
+	}
+	return owners, rows.Err()
+}
+
+// Orphaned returns the companies that need an owner: paired sessions
+// without a lease and leases that expired, usually because their owner died.
+func (r *Repository) Orphaned(ctx context.Context) ([]string, error) {
+	ctx = db.WithQueryName(ctx, "leases.orphaned")
+	rows, err := r.db.Query(ctx, `
+        SELECT s.company_id FROM sessions s
+        WHERE NOT EXISTS (SELECT 1 FROM session_leases l WHERE l.company_id = s.company_id)
+        UNION
+        SELECT company_id FROM session_leases WHERE expires_at < now()
+        ORDER BY 1
+    `)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+func scanIDs(rows pgx.Rows) ([]string, error) {
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,135 @@
+// Package logging configures zerolog and redacts personal data from logs.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Config controls the log output.
+type Config struct {
+	// Level is the minimum level, e.g. "debug" or "info".
+	Level string
+	// Format is "json" or "console".
+	Format string
+	// WhatsmeowLevel is the minimum level of whatsmeow's own logs.
+	WhatsmeowLevel string
+	// RedactContent hides message text from the logs.
+	RedactContent bool
+	// RedactPhones masks phone numbers and JIDs, keeping the last digits.
+	RedactPhones bool
+}
+
+var (
+	redactContent  bool
+	redactPhones   bool
+	whatsmeowLevel = zerolog.InfoLevel
+)
+
+// Setup installs the global logger. Loggers taken from a context without
+// one fall back to it.
+func Setup(cfg Config) error {
+	level, err := parseLevel(cfg.Level, zerolog.InfoLevel)
+	if err != nil {
+		return err
+	}
+	whatsmeowLevel, err = parseLevel(cfg.WhatsmeowLevel, zerolog.InfoLevel)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	switch cfg.Format {
+	case "", "console":
+		out = zerolog.ConsoleWriter{Out: os.Stdout}
+	case "json":
+	default:
+		return fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+
+	log.Logger = zerolog.New(out).Level(level).With().Timestamp().Logger()
+	zerolog.DefaultContextLogger = &log.Logger
+	redactContent = cfg.RedactContent
+	redactPhones = cfg.RedactPhones
+	return nil
+}
+
+func parseLevel(s string, def zerolog.Level) (zerolog.Level, error) {
+	if s == "" {
+		return def, nil
+	}
+	level, err := zerolog.ParseLevel(strings.ToLower(s))
+	if err != nil {
+		return def, fmt.Errorf("invalid log level %q", s)
+	}
+	return level, nil
+}
+
+// Content returns s, or a placeholder when message content is redacted.
+func Content(s string) string {
+	if !redactContent || s == "" {
+		return s
+	}
+	return fmt.Sprintf("[redacted %d chars]", len(s))
+}
+
+// digits matches phone numbers, alone or as the user part of a JID.
+var digits = regexp.MustCompile(`\+?\d{8,}`)
+
+// Phone masks every phone number in s, keeping the last four digits, when
+// phone numbers are redacted.
+func Phone(s string) string {
+	if !redactPhones {
+		return s
+	}
+	return maskPhones(s)
+}
+
+func maskPhones(s string) string {
+	return digits.ReplaceAllStringFunc(s, func(n string) string {
+		return strings.Repeat("*", len(n)-4) + n[len(n)-4:]
+	})
+}
+
+// WA adapts logger to whatsmeow's logger interface at the configured
+// whatsmeow level. Phone numbers and JIDs in its messages, including the
+// XML dumps of the debug level, are masked when RedactPhones is set.
+func WA(logger zerolog.Logger) waLog.Logger {
+	return &waLogger{log: logger.Level(whatsmeowLevel), redact: redactPhones}
+}
+
+type waLogger struct {
+	log    zerolog.Logger
+	module string
+	redact bool
+}
+
+func (l *waLogger) Warnf(msg string, args ...any)  { l.emit(l.log.Warn(), msg, args) }
+func (l *waLogger) Errorf(msg string, args ...any) { l.emit(l.log.Error(), msg, args) }
+func (l *waLogger) Infof(msg string, args ...any)  { l.emit(l.log.Info(), msg, args) }
+func (l *waLogger) Debugf(msg string, args ...any) { l.emit(l.log.Debug(), msg, args) }
+
+func (l *waLogger) Sub(module string) waLog.Logger {
+	if l.module != "" {
+		module = l.module + "/" + module
+	}
+	return &waLogger{log: l.log.With().Str("module", module).Logger(), module: module, redact: l.redact}
+}
+
+func (l *waLogger) emit(e *zerolog.Event, msg string, args []any) {
+	if !e.Enabled() {
+		return
+	}
+	msg = fmt.Sprintf(msg, args...)
+	if l.redact {
+		msg = maskPhones(msg)
+	}
+	e.Msg(msg)
+}
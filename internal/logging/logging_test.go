@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestWARedactsPhones(t *testing.T) {
+	defer func(redact bool, level zerolog.Level) { redactPhones, whatsmeowLevel = redact, level }(redactPhones, whatsmeowLevel)
+	whatsmeowLevel = zerolog.DebugLevel
+
+	tests := []struct {
+		name   string
+		redact bool
+		msg    string
+		args   []any
+		want   string
+	}{
+		{
+			name:   "jid",
+			redact: true,
+			msg:    "Sending message to %s",
+			args:   []any{"5511999999999@s.whatsapp.net"},
+			want:   "Sending message to *********9999@s.whatsapp.net",
+		},
+		{
+			name:   "device jid and lid in xml",
+			redact: true,
+			msg:    "%s",
+			args:   []any{`<receipt from="5511988887777:12@s.whatsapp.net" participant="123456789012345@lid"/>`},
+			want:   `<receipt from="*********7777:12@s.whatsapp.net" participant="***********2345@lid"/>`,
+		},
+		{
+			name:   "e164",
+			redact: true,
+			msg:    "Checking +5511999999999",
+			want:   "Checking **********9999",
+		},
+		{
+			name:   "short numbers",
+			redact: true,
+			msg:    "Got %d prekeys, retry %d",
+			args:   []any{812, 3},
+			want:   "Got 812 prekeys, retry 3",
+		},
+		{
+			name: "not redacted",
+			msg:  "Sending message to %s",
+			args: []any{"5511999999999@s.whatsapp.net"},
+			want: "Sending message to 5511999999999@s.whatsapp.net",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redactPhones = tt.redact
+			var buf bytes.Buffer
+			// Sub loggers keep the setting of their parent.
+			wa := WA(zerolog.New(&buf)).Sub("Client").Sub("Send")
+			wa.Debugf(tt.msg, tt.args...)
+
+			var line struct {
+				Message string `json:"message"`
+				Module  string `json:"module"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatalf("decode %q: %v", buf.String(), err)
+			}
+			if line.Message != tt.want {
+				t.Errorf("message = %q, want %q", line.Message, tt.want)
+			}
+			if line.Module != "Client/Send" {
+				t.Errorf("module = %q, want Client/Send", line.Module)
+			}
+		})
+	}
+}
+
+func TestWALevel(t *testing.T) {
+	defer func(level zerolog.Level) { whatsmeowLevel = level }(whatsmeowLevel)
+	whatsmeowLevel = zerolog.WarnLevel
+
+	var buf bytes.Buffer
+	wa := WA(zerolog.New(&buf))
+	wa.Infof("connected")
+	wa.Debugf("sent node")
+	if buf.Len() != 0 {
+		t.Errorf("logged below the whatsmeow level: %s", buf.String())
+	}
+	wa.Warnf("stream error")
+	if buf.Len() == 0 {
+		t.Error("warning not logged")
+	}
+}
@@ -2,10 +2,22 @@ package messages
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/example/wpp-wave-bot/internal/db"
 )
 
+// ErrInvalidCursor is returned when a pagination cursor can't be parsed.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
 // Repository provides helpers to persist messages.
 type Repository struct {
 	db *pgxpool.Pool
@@ -16,21 +28,404 @@ func NewRepository(db *pgxpool.Pool) *Repository {
 	return &Repository{db: db}
 }
 
+// Message is a stored message row. Receiver holds the chat JID for both
+// directions.
+type Message struct {
+	ID        int64           `json:"id"`
+	CompanyID string          `json:"company_id"`
+	MsgID     string          `json:"msg_id"`
+	Sender    string          `json:"sender"`
+	Receiver  string          `json:"receiver"`
+	FromMe    bool            `json:"from_me"`
+	Type      string          `json:"type"`
+	Content   string          `json:"content"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Status    string          `json:"status"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Filter narrows down the messages returned by List. Zero values are ignored.
+type Filter struct {
+	Chat   string
+	Sender string
+	Type   string
+	Status string
+	Since  time.Time
+	Until  time.Time
+	Cursor string
+	Limit  int
+}
+
+// Chat summarizes a conversation by its most recent message.
+type Chat struct {
+	JID         string  `json:"jid"`
+	Name        string  `json:"name"`
+	IsGroup     bool    `json:"is_group"`
+	Unread      int     `json:"unread"`
+	LastMessage Message `json:"last_message"`
+}
+
+const messageColumns = `id, company_id, msg_id, sender, receiver, from_me, type, COALESCE(content, '') AS content,
+        payload, COALESCE(status, '') AS status, created_at, updated_at`
+
 // Save inserts a message record.
-func (r *Repository) Save(ctx context.Context, companyID, msgID, sender, receiver, msgType, content, payload, status string) error {
+func (r *Repository) Save(ctx context.Context, companyID, msgID, sender, receiver, msgType, content, payload, status string, fromMe bool) error {
+	ctx = db.WithQueryName(ctx, "messages.save")
 	_, err := r.db.Exec(ctx,
-		`INSERT INTO messages (company_id, msg_id, sender, receiver, type, content, payload, status)
-         VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
-		companyID, msgID, sender, receiver, msgType, content, payload, status,
+		`INSERT INTO messages (company_id, msg_id, sender, receiver, type, content, payload, status, from_me)
+         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		companyID, msgID, sender, receiver, msgType, content, payload, status, fromMe,
 	)
 	return err
 }
 
+// Reserve inserts a pending outgoing message before it is sent and returns its
+// row ID. When another message of the company already holds idempotencyKey
+// nothing is inserted and that message is returned instead, unless it failed,
+// in which case the row is taken over for the retry.
+func (r *Repository) Reserve(ctx context.Context, companyID, msgID, sender, receiver, msgType, content, idempotencyKey string) (id int64, existing *Message, err error) {
+	ctx = db.WithQueryName(ctx, "messages.reserve")
+	err = r.db.QueryRow(ctx,
+		`INSERT INTO messages (company_id, msg_id, sender, receiver, type, content, status, from_me, idempotency_key)
+         VALUES ($1, $2, $3, $4, $5, $6, 'pending', true, NULLIF($7, ''))
+         ON CONFLICT (company_id, idempotency_key) WHERE idempotency_key IS NOT NULL DO UPDATE
+             SET msg_id = EXCLUDED.msg_id,
+                 sender = EXCLUDED.sender,
+                 receiver = EXCLUDED.receiver,
+                 type = EXCLUDED.type,
+                 content = EXCLUDED.content,
+                 payload = NULL,
+                 status = 'pending',
+                 error = NULL,
+                 updated_at = now()
+             WHERE messages.status = 'failed'
+         RETURNING id`,
+		companyID, msgID, sender, receiver, msgType, content, idempotencyKey,
+	).Scan(&id)
+	if err == nil {
+		return id, nil, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil, err
+	}
+
+	existing = &Message{}
+	err = scanMessage(r.db.QueryRow(ctx,
+		`SELECT `+messageColumns+` FROM messages WHERE company_id = $1 AND idempotency_key = $2`,
+		companyID, idempotencyKey,
+	), existing)
+	if err != nil {
+		return 0, nil, err
+	}
+	return existing.ID, existing, nil
+}
+
+// MarkSent records the payload of a reserved message once WhatsApp accepted it.
+func (r *Repository) MarkSent(ctx context.Context, companyID, msgID, payload string) error {
+	ctx = db.WithQueryName(ctx, "messages.mark_sent")
+	_, err := r.db.Exec(ctx,
+		`UPDATE messages SET status='sent', payload=$1, updated_at=now() WHERE company_id=$2 AND msg_id=$3`,
+		payload, companyID, msgID,
+	)
+	return err
+}
+
+// Release deletes a reserved message that was never sent, e.g. because the
+// rate limits rejected it, so its idempotency key can be used again.
+func (r *Repository) Release(ctx context.Context, companyID, msgID string) error {
+	ctx = db.WithQueryName(ctx, "messages.release")
+	_, err := r.db.Exec(ctx,
+		`DELETE FROM messages WHERE company_id=$1 AND msg_id=$2 AND status='pending'`,
+		companyID, msgID,
+	)
+	return err
+}
+
+// MarkFailed records why a reserved message could not be sent.
+func (r *Repository) MarkFailed(ctx context.Context, companyID, msgID, reason string) error {
+	ctx = db.WithQueryName(ctx, "messages.mark_failed")
+	_, err := r.db.Exec(ctx,
+		`UPDATE messages SET status='failed', error=$1, updated_at=now() WHERE company_id=$2 AND msg_id=$3`,
+		reason, companyID, msgID,
+	)
+	return err
+}
+
+// Fail records an outgoing message that failed before it could be reserved,
+// e.g. because its recipient is invalid. Like a failed send it releases
+// idempotencyKey for a retry, and it records nothing when the key belongs to
+// a message that didn't fail.
+func (r *Repository) Fail(ctx context.Context, companyID, msgID, sender, receiver, msgType, content, idempotencyKey, reason string) error {
+	ctx = db.WithQueryName(ctx, "messages.fail")
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO messages (company_id, msg_id, sender, receiver, type, content, status, error, from_me, idempotency_key)
+         VALUES ($1, $2, $3, $4, $5, $6, 'failed', $7, true, NULLIF($8, ''))
+         ON CONFLICT (company_id, idempotency_key) WHERE idempotency_key IS NOT NULL DO UPDATE
+             SET msg_id = EXCLUDED.msg_id,
+                 sender = EXCLUDED.sender,
+                 receiver = EXCLUDED.receiver,
+                 type = EXCLUDED.type,
+                 content = EXCLUDED.content,
+                 payload = NULL,
+                 error = EXCLUDED.error,
+                 updated_at = now()
+             WHERE messages.status = 'failed'`,
+		companyID, msgID, sender, receiver, msgType, content, reason, idempotencyKey,
+	)
+	return err
+}
+
+// UpdateReceipt sets the status of a message we sent from a delivery or read
+// receipt, reporting whether there was such a message.
+func (r *Repository) UpdateReceipt(ctx context.Context, companyID, msgID, status string) (bool, error) {
+	ctx = db.WithQueryName(ctx, "messages.update_receipt")
+	tag, err := r.db.Exec(ctx,
+		`UPDATE messages SET status=$1, updated_at=now() WHERE company_id=$2 AND msg_id=$3 AND from_me`,
+		status, companyID, msgID,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
 // UpdateStatus updates the status of a message
 func (r *Repository) UpdateStatus(ctx context.Context, companyID, msgID, status string) error {
+	ctx = db.WithQueryName(ctx, "messages.update_status")
 	_, err := r.db.Exec(ctx,
 		`UPDATE messages SET status=$1, updated_at=now() WHERE company_id=$2 AND msg_id=$3`,
 		status, companyID, msgID,
 	)
 	return err
 }
+
+// List returns messages of a company matching the filter, newest first. The
+// returned cursor fetches the next page and is empty on the last one.
+func (r *Repository) List(ctx context.Context, companyID string, f Filter) ([]Message, string, error) {
+	ctx = db.WithQueryName(ctx, "messages.list")
+	where := []string{"company_id = $1"}
+	args := []any{companyID}
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Chat != "" {
+		add("receiver = $%d", f.Chat)
+	}
+	if f.Sender != "" {
+		add("sender = $%d", f.Sender)
+	}
+	if f.Type != "" {
+		add("type = $%d", f.Type)
+	}
+	if f.Status != "" {
+		add("status = $%d", f.Status)
+	}
+	if !f.Since.IsZero() {
+		add("created_at >= $%d", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("created_at < $%d", f.Until)
+	}
+	if f.Cursor != "" {
+		before, err := strconv.ParseInt(f.Cursor, 10, 64)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		add("id < $%d", before)
+	}
+	limit := pageSize(f.Limit)
+	args = append(args, limit+1)
+
+	rows, err := r.db.Query(ctx, fmt.Sprintf(
+		`SELECT %s FROM messages WHERE %s ORDER BY id DESC LIMIT $%d`,
+		messageColumns, strings.Join(where, " AND "), len(args),
+	), args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	list := make([]Message, 0, limit)
+	for rows.Next() {
+		var m Message
+		if err := scanMessage(rows, &m); err != nil {
+			return nil, "", err
+		}
+		list = append(list, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	return paginate(list, limit)
+}
+
+// Chats returns the conversations of a company ordered by latest activity.
+// Unread counts the incoming messages received since our last reply.
+func (r *Repository) Chats(ctx context.Context, companyID, cursor string, limit int) ([]Chat, string, error) {
+	ctx = db.WithQueryName(ctx, "messages.chats")
+	before := int64(0)
+	if cursor != "" {
+		var err error
+		if before, err = strconv.ParseInt(cursor, 10, 64); err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+	}
+	limit = pageSize(limit)
+
+	rows, err := r.db.Query(ctx, `
+        WITH last AS (
+            SELECT DISTINCT ON (receiver) `+messageColumns+`
+            FROM messages
+            WHERE company_id = $1
+            ORDER BY receiver, id DESC
+        )
+        SELECT l.*,
+               COALESCE(g.name, c.name, ''),
+               g.jid IS NOT NULL OR l.receiver LIKE '%@g.us',
+               (SELECT count(*) FROM messages m
+                WHERE m.company_id = $1 AND m.receiver = l.receiver AND NOT m.from_me
+                  AND m.id > COALESCE((SELECT max(o.id) FROM messages o
+                                       WHERE o.company_id = $1 AND o.receiver = l.receiver AND o.from_me), 0))
+        FROM last l
+        LEFT JOIN contacts c ON c.jid = l.receiver AND c.company_id = $1
+        LEFT JOIN groups g ON g.jid = l.receiver AND g.company_id = $1
+        WHERE $2 = 0 OR l.id < $2
+        ORDER BY l.id DESC
+        LIMIT $3
+    `, companyID, before, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	chats := make([]Chat, 0, limit)
+	for rows.Next() {
+		var c Chat
+		m := &c.LastMessage
+		var payload []byte
+		if err := rows.Scan(&m.ID, &m.CompanyID, &m.MsgID, &m.Sender, &m.Receiver, &m.FromMe, &m.Type, &m.Content,
+			&payload, &m.Status, &m.CreatedAt, &m.UpdatedAt, &c.Name, &c.IsGroup, &c.Unread); err != nil {
+			return nil, "", err
+		}
+		m.Payload = payload
+		c.JID = m.Receiver
+		chats = append(chats, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(chats) > limit {
+		chats = chats[:limit]
+		next = strconv.FormatInt(chats[limit-1].LastMessage.ID, 10)
+	}
+	return chats, next, nil
+}
+
+// SearchResult is a message matching a search query along with an HTML
+// escaped snippet of the matched text where hits are wrapped in <mark> tags.
+type SearchResult struct {
+	Message
+	Highlight string `json:"highlight"`
+}
+
+// escapedText is search_text with the HTML special characters escaped, so
+// that the <mark> tags added by ts_headline are the only markup in a
+// highlight.
+const escapedText = `replace(replace(replace(replace(replace(search_text,
+        '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'), '''', '&#39;')`
+
+// Search finds messages of a company whose text, captions or filenames match
+// query. Postgres full-text search with the Portuguese configuration is used
+// first, falling back to trigram similarity for partial words and typos.
+// Results are ranked, so the returned cursor is the offset of the next page,
+// empty on the last one.
+func (r *Repository) Search(ctx context.Context, companyID, query, chat, cursor string, limit int) ([]SearchResult, string, error) {
+	ctx = db.WithQueryName(ctx, "messages.search")
+	offset := 0
+	if cursor != "" {
+		var err error
+		if offset, err = strconv.Atoi(cursor); err != nil || offset < 0 {
+			return nil, "", ErrInvalidCursor
+		}
+	}
+	limit = pageSize(limit)
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+
+	rows, err := r.db.Query(ctx, `
+        SELECT `+messageColumns+`,
+               ts_headline('portuguese', `+escapedText+`, q, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MinWords=5, MaxWords=20')
+        FROM messages, websearch_to_tsquery('portuguese', $2) q
+        WHERE company_id = $1
+          AND ($4 = '' OR receiver = $4)
+          AND (to_tsvector('portuguese', search_text) @@ q
+               OR search_text ILIKE $3
+               OR $2 <% search_text)
+        ORDER BY ts_rank(to_tsvector('portuguese', search_text), q) DESC,
+                 word_similarity($2, search_text) DESC,
+                 id DESC
+        LIMIT $5 OFFSET $6
+    `, companyID, query, pattern, chat, limit+1, offset)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	results := make([]SearchResult, 0, limit)
+	for rows.Next() {
+		var res SearchResult
+		m := &res.Message
+		var payload []byte
+		if err := rows.Scan(&m.ID, &m.CompanyID, &m.MsgID, &m.Sender, &m.Receiver, &m.FromMe, &m.Type, &m.Content,
+			&payload, &m.Status, &m.CreatedAt, &m.UpdatedAt, &res.Highlight); err != nil {
+			return nil, "", err
+		}
+		m.Payload = payload
+		results = append(results, res)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(results) > limit {
+		results = results[:limit]
+		next = strconv.Itoa(offset + limit)
+	}
+	return results, next, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMessage(row scanner, m *Message) error {
+	var payload []byte
+	if err := row.Scan(&m.ID, &m.CompanyID, &m.MsgID, &m.Sender, &m.Receiver, &m.FromMe, &m.Type, &m.Content,
+		&payload, &m.Status, &m.CreatedAt, &m.UpdatedAt); err != nil {
+		return err
+	}
+	m.Payload = payload
+	return nil
+}
+
+func paginate(list []Message, limit int) ([]Message, string, error) {
+	if len(list) <= limit {
+		return list, "", nil
+	}
+	list = list[:limit]
+	return list, strconv.FormatInt(list[limit-1].ID, 10), nil
+}
+
+func pageSize(limit int) int {
+	switch {
+	case limit <= 0:
+		return 50
+	case limit > 200:
+		return 200
+	}
+	return limit
+}
@@ -0,0 +1,148 @@
+// Package metrics defines the Prometheus metrics of the service.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "wpp"
+
+// sizeBuckets spans media sizes from 1 KiB to 64 MiB.
+var sizeBuckets = prometheus.ExponentialBuckets(1024, 4, 9)
+
+var (
+	MessagesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_sent_total",
+		Help:      "Messages sent, by company and type.",
+	}, []string{"company_id", "type"})
+	MessagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_failed_total",
+		Help:      "Messages that failed to send, by company and type.",
+	}, []string{"company_id", "type"})
+	MessagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_received_total",
+		Help:      "Messages received, by company and type.",
+	}, []string{"company_id", "type"})
+	SendDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "send_duration_seconds",
+		Help:      "Time to send a message, including media download and upload.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"type"})
+
+	MediaDownloadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "media_download_duration_seconds",
+		Help:      "Time to download the media of outgoing messages.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	})
+	MediaDownloadBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "media_download_bytes",
+		Help:      "Size of the media downloaded for outgoing messages.",
+		Buckets:   sizeBuckets,
+	})
+	MediaUploadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "media_upload_duration_seconds",
+		Help:      "Time to upload media to WhatsApp, by media type.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"media"})
+	MediaUploadBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "media_upload_bytes",
+		Help:      "Size of the media uploaded to WhatsApp, by media type.",
+		Buckets:   sizeBuckets,
+	}, []string{"media"})
+
+	SessionDisconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "session_disconnects_total",
+		Help:      "Lost connections, by company.",
+	}, []string{"company_id"})
+	SessionReconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "session_reconnects_total",
+		Help:      "Reconnects forced by the watchdog, by company.",
+	}, []string{"company_id"})
+
+	RateLimitWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_wait_seconds",
+		Help:      "Time sends waited for the rate limits, by the limit that held them (global, company or recipient).",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"scope"})
+	RateLimitWaiting = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_waiting",
+		Help:      "Sends waiting for the rate limits, by company.",
+	}, []string{"company_id"})
+	RateLimitRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejected_total",
+		Help:      "API sends rejected because they would wait too long for the rate limits, by company and limit.",
+	}, []string{"company_id", "scope"})
+
+	WebhookDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_events_dropped_total",
+		Help:      "Webhook events and retries dropped because the delivery queue was full, by company.",
+	}, []string{"company_id"})
+
+	QueueErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rabbitmq_errors_total",
+		Help:      "RabbitMQ errors, by operation (consume or publish) and queue.",
+	}, []string{"operation", "queue"})
+	QueueLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "queue_lag_seconds",
+		Help:      "Time between publishing and consuming a message, by queue.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"queue"})
+
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "PostgreSQL query latency, by query name, or statement (select, insert, update, delete or other) for unnamed queries.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 4, 9),
+	}, []string{"query"})
+)
+
+// sessionCollector reports the number of sessions in each state at scrape
+// time.
+type sessionCollector struct {
+	desc   *prometheus.Desc
+	states func() map[string]int
+}
+
+func (c *sessionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *sessionCollector) Collect(ch chan<- prometheus.Metric) {
+	for state, n := range c.states() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n), state)
+	}
+}
+
+// RegisterSessions exports wpp_sessions, the number of sessions by state,
+// calling states on every scrape.
+func RegisterSessions(states func() map[string]int) {
+	prometheus.MustRegister(&sessionCollector{
+		desc:   prometheus.NewDesc(namespace+"_sessions", "Loaded sessions, by state.", []string{"state"}, nil),
+		states: states,
+	})
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -1,36 +1,207 @@
 package rabbitmq
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/rs/zerolog/log"
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/example/wpp-wave-bot/internal/metrics"
+	"github.com/example/wpp-wave-bot/internal/tracing"
+)
+
+// prefetch is how many unacknowledged deliveries the broker sends ahead.
+const prefetch = 10
+
+// Delays between attempts to reopen a lost channel or connection.
+const (
+	reconnectMin = time.Second
+	reconnectMax = 30 * time.Second
 )
 
 // RabbitMQ wraps an AMQP connection and channels
 // to publish and consume messages
 // Using a single connection for simplicity
-
+//
+// When the broker closes the channel, e.g. after a channel exception, or the
+// connection drops, both are reopened and the consumers resume on the new
+// channel. Their delivery channels stay open meanwhile; deliveries that were
+// not acknowledged yet are requeued by the broker and their Ack fails.
 type RabbitMQ struct {
+	url string
+
+	// mu guards conn and channel, which are replaced on reconnect.
+	mu      sync.RWMutex
 	conn    *amqp.Connection
 	channel *amqp.Channel
+	// channelClosed is set while the channel is down.
+	channelClosed atomic.Bool
+	// declared caches the queues declared by this connection.
+	declared sync.Map
+
+	consumersMu sync.Mutex
+	consumers   map[string]*consumer
+	tags        atomic.Uint64
+
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 // New creates a connection to RabbitMQ
 func New(url string) (*RabbitMQ, error) {
-	conn, err := amqp.Dial(url)
+	r := &RabbitMQ{url: url, consumers: make(map[string]*consumer), done: make(chan struct{})}
+	closed, err := r.open()
 	if err != nil {
-		return nil, fmt.Errorf("dial rabbitmq: %w", err)
+		return nil, err
+	}
+	go r.watch(closed)
+	return r, nil
+}
+
+// open dials the broker unless the connection is still up and opens a new
+// channel, returning a channel notified when it closes.
+func (r *RabbitMQ) open() (chan *amqp.Error, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil || r.conn.IsClosed() {
+		conn, err := amqp.Dial(r.url)
+		if err != nil {
+			return nil, fmt.Errorf("dial rabbitmq: %w", err)
+		}
+		r.conn = conn
 	}
-	ch, err := conn.Channel()
+	ch, err := r.conn.Channel()
 	if err != nil {
-		conn.Close()
+		r.conn.Close()
 		return nil, fmt.Errorf("open channel: %w", err)
 	}
-	return &RabbitMQ{conn: conn, channel: ch}, nil
+	// bound the unacknowledged deliveries held by consumers
+	if err := ch.Qos(prefetch, 0, false); err != nil {
+		r.conn.Close()
+		return nil, fmt.Errorf("set qos: %w", err)
+	}
+	r.channel = ch
+	r.declared.Clear()
+	r.channelClosed.Store(false)
+	go r.watchCancels(ch.NotifyCancel(make(chan string, 16)))
+	// closing the connection closes the channel too
+	return ch.NotifyClose(make(chan *amqp.Error, 1)), nil
+}
+
+// watchCancels ends the consumers the broker cancelled, e.g. because their
+// queue was deleted, until the channel closes.
+func (r *RabbitMQ) watchCancels(cancels chan string) {
+	for tag := range cancels {
+		r.consumersMu.Lock()
+		c, ok := r.consumers[tag]
+		delete(r.consumers, tag)
+		r.consumersMu.Unlock()
+		if ok {
+			log.Warn().Str("consumer", tag).Msg("consumer cancelled by the broker")
+			c.cancel()
+		}
+	}
+}
+
+// watch reopens the channel whenever it closes and resumes the consumers on
+// it, until Close is called.
+func (r *RabbitMQ) watch(closed chan *amqp.Error) {
+	for {
+		select {
+		case <-r.done:
+			return
+		case err := <-closed:
+			r.channelClosed.Store(true)
+			select {
+			case <-r.done:
+				return
+			default:
+			}
+			log.Warn().Err(err).Msg("rabbitmq channel closed, reconnecting")
+		}
+
+		delay := reconnectMin
+		for {
+			var err error
+			if closed, err = r.open(); err == nil {
+				break
+			}
+			log.Error().Err(err).Dur("retry_in", delay).Msg("failed to reconnect to rabbitmq")
+			select {
+			case <-r.done:
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, reconnectMax)
+		}
+		log.Info().Msg("rabbitmq reconnected")
+		r.resume()
+	}
+}
+
+// resume starts the consumers again on the current channel.
+func (r *RabbitMQ) resume() {
+	r.consumersMu.Lock()
+	defer r.consumersMu.Unlock()
+	for tag, c := range r.consumers {
+		if c.exchange != "" {
+			// the exclusive queue went away with the old connection
+			queue, err := r.bind(c.exchange, c.key)
+			if err != nil {
+				log.Error().Err(err).Str("exchange", c.exchange).Msg("failed to resume subscription")
+				return
+			}
+			c.queue = queue
+		}
+		msgs, err := r.ch().Consume(c.queue, tag, false, false, false, false, nil)
+		if err != nil {
+			metrics.QueueErrors.WithLabelValues("consume", c.queue).Inc()
+			log.Error().Err(err).Str("queue", c.queue).Msg("failed to resume consumer")
+			// the channel is closed again and watch retries
+			return
+		}
+		// replace a channel the consumer hasn't switched to yet
+		select {
+		case <-c.next:
+		default:
+		}
+		c.next <- msgs
+	}
+}
+
+// ch returns the current channel.
+func (r *RabbitMQ) ch() *amqp.Channel {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.channel
+}
+
+// Check reports whether the connection and channel are still open.
+func (r *RabbitMQ) Check() error {
+	r.mu.RLock()
+	conn := r.conn
+	r.mu.RUnlock()
+	if conn.IsClosed() {
+		return errors.New("connection closed")
+	}
+	if r.channelClosed.Load() {
+		return errors.New("channel closed")
+	}
+	return nil
 }
 
 // Close shuts down channel and connection
 func (r *RabbitMQ) Close() {
+	r.closeOnce.Do(func() { close(r.done) })
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if r.channel != nil {
 		r.channel.Close()
 	}
@@ -39,15 +210,223 @@ func (r *RabbitMQ) Close() {
 	}
 }
 
-// Consume returns a delivery channel for the given queue
-func (r *RabbitMQ) Consume(queue string) (<-chan amqp.Delivery, error) {
-	return r.channel.Consume(queue, "", true, false, false, false, nil)
+// consumer forwards the deliveries of the channel it currently consumes from
+// to out, switching to the channels sent to next after reconnects.
+type consumer struct {
+	queue string
+	// exchange and key are set for subscriptions, whose queue is recreated
+	// on reconnect
+	exchange  string
+	key       string
+	out       chan amqp.Delivery
+	next      chan (<-chan amqp.Delivery)
+	cancelled chan struct{}
+	once      sync.Once
+}
+
+func newConsumer(queue string) *consumer {
+	return &consumer{
+		queue:     queue,
+		out:       make(chan amqp.Delivery),
+		next:      make(chan (<-chan amqp.Delivery), 1),
+		cancelled: make(chan struct{}),
+	}
+}
+
+// run forwards deliveries until the consumer is cancelled or done is closed,
+// then closes out.
+func (c *consumer) run(msgs <-chan amqp.Delivery, done <-chan struct{}) {
+	defer close(c.out)
+	for {
+		for d := range msgs {
+			c.out <- d
+		}
+		// the broker confirmed a cancel or the channel closed
+		select {
+		case <-c.cancelled:
+			return
+		case <-done:
+			return
+		case msgs = <-c.next:
+		}
+	}
+}
+
+func (c *consumer) cancel() {
+	c.once.Do(func() { close(c.cancelled) })
+}
+
+// Consume returns a delivery channel for the given queue and the consumer
+// tag identifying it, unique so a queue can be consumed more than once.
+// Deliveries must be acknowledged; unacknowledged ones are requeued when the
+// channel closes. The delivery channel survives reconnects and is closed
+// after Cancel.
+func (r *RabbitMQ) Consume(queue string) (string, <-chan amqp.Delivery, error) {
+	tag := r.tag(queue)
+	r.consumersMu.Lock()
+	defer r.consumersMu.Unlock()
+	msgs, err := r.ch().Consume(queue, tag, false, false, false, false, nil)
+	if err != nil {
+		metrics.QueueErrors.WithLabelValues("consume", queue).Inc()
+		return "", nil, err
+	}
+	c := newConsumer(queue)
+	r.consumers[tag] = c
+	go c.run(msgs, r.done)
+	return tag, c.out, nil
+}
+
+// Subscribe consumes the messages published to an exchange with routing key
+// from then on through a queue of its own, which is deleted when the consumer
+// or the connection goes away. Fanout exchanges ignore the key. It returns
+// the consumer tag and the delivery channel like Consume.
+func (r *RabbitMQ) Subscribe(exchange, key string) (string, <-chan amqp.Delivery, error) {
+	tag := r.tag(exchange)
+	r.consumersMu.Lock()
+	defer r.consumersMu.Unlock()
+	queue, err := r.bind(exchange, key)
+	if err != nil {
+		return "", nil, err
+	}
+	msgs, err := r.ch().Consume(queue, tag, false, true, false, false, nil)
+	if err != nil {
+		metrics.QueueErrors.WithLabelValues("consume", exchange).Inc()
+		return "", nil, err
+	}
+	c := newConsumer(queue)
+	c.exchange, c.key = exchange, key
+	r.consumers[tag] = c
+	go c.run(msgs, r.done)
+	return tag, c.out, nil
 }
 
-// Publish sends a message to an exchange with routing key
-func (r *RabbitMQ) Publish(exchange, key string, body []byte) error {
-	return r.channel.Publish(exchange, key, false, false, amqp.Publishing{
-		ContentType: "application/json",
-		Body:        body,
+// bind declares an exclusive queue bound to exchange with key and returns
+// its name.
+func (r *RabbitMQ) bind(exchange, key string) (string, error) {
+	ch := r.ch()
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err == nil {
+		err = ch.QueueBind(q.Name, key, exchange, false, nil)
+	}
+	if err != nil {
+		metrics.QueueErrors.WithLabelValues("declare", exchange).Inc()
+		return "", err
+	}
+	return q.Name, nil
+}
+
+// tag returns a new consumer tag for queue. Tags must be unique on the
+// channel, or the broker closes it.
+func (r *RabbitMQ) tag(queue string) string {
+	return queue + "#" + strconv.FormatUint(r.tags.Add(1), 10)
+}
+
+// Declare creates a durable queue unless this connection already declared
+// it.
+func (r *RabbitMQ) Declare(queue string) error {
+	if _, ok := r.declared.Load(queue); ok {
+		return nil
+	}
+	if _, err := r.ch().QueueDeclare(queue, true, false, false, false, nil); err != nil {
+		metrics.QueueErrors.WithLabelValues("declare", queue).Inc()
+		return err
+	}
+	r.declared.Store(queue, true)
+	return nil
+}
+
+// DeclareFanout creates a durable fanout exchange, which copies every
+// message to each queue bound to it.
+func (r *RabbitMQ) DeclareFanout(exchange string) error {
+	return r.declareExchange(exchange, amqp.ExchangeFanout)
+}
+
+// DeclareDirect creates a durable direct exchange, which delivers every
+// message to the queues bound with its routing key.
+func (r *RabbitMQ) DeclareDirect(exchange string) error {
+	return r.declareExchange(exchange, amqp.ExchangeDirect)
+}
+
+func (r *RabbitMQ) declareExchange(exchange, kind string) error {
+	if err := r.ch().ExchangeDeclare(exchange, kind, true, false, false, false, nil); err != nil {
+		metrics.QueueErrors.WithLabelValues("declare", exchange).Inc()
+		return err
+	}
+	return nil
+}
+
+// Cancel stops the consumer with tag. Its delivery channel is closed once
+// the deliveries already received were read; they can still be
+// acknowledged. Consumers the broker cancelled already are left alone.
+func (r *RabbitMQ) Cancel(tag string) error {
+	r.consumersMu.Lock()
+	c, ok := r.consumers[tag]
+	delete(r.consumers, tag)
+	r.consumersMu.Unlock()
+	if !ok {
+		return nil
+	}
+	c.cancel()
+	if r.channelClosed.Load() {
+		// nothing to cancel on the broker; the deliveries were requeued
+		return nil
+	}
+	return r.ch().Cancel(tag, false)
+}
+
+// Publish sends a message to an exchange with routing key. The publishing
+// time is set so consumers can measure the queue lag, and the trace context
+// of ctx travels in the headers.
+func (r *RabbitMQ) Publish(ctx context.Context, exchange, key string, body []byte) error {
+	return r.publish(ctx, exchange, key, amqp.Publishing{Body: body})
+}
+
+// Broadcast publishes a message to a fanout exchange like Publish, marking
+// it with origin so the publisher can recognize its own messages by their
+// AppId.
+func (r *RabbitMQ) Broadcast(ctx context.Context, exchange, origin string, body []byte) error {
+	return r.publish(ctx, exchange, "", amqp.Publishing{AppId: origin, Body: body})
+}
+
+// publish sends msg as JSON, setting its headers and publishing time.
+func (r *RabbitMQ) publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	dest := key
+	if dest == "" {
+		dest = exchange
+	}
+	ctx, span := tracing.Start(ctx, "publish "+dest, trace.WithSpanKind(trace.SpanKindProducer), tracing.QueueAttrs(dest))
+	msg.Headers = amqp.Table{}
+	tracing.Inject(ctx, msg.Headers)
+	msg.ContentType = "application/json"
+	msg.Timestamp = time.Now()
+	err := r.ch().Publish(exchange, key, false, false, msg)
+	if err != nil {
+		metrics.QueueErrors.WithLabelValues("publish", dest).Inc()
+	}
+	tracing.End(span, err)
+	return err
+}
+
+// Forward republishes a consumed delivery to queue. Its headers and
+// publishing time are kept, so the queue lag covers both queues and the
+// trace continues from ctx.
+func (r *RabbitMQ) Forward(ctx context.Context, queue string, d amqp.Delivery) error {
+	ctx, span := tracing.Start(ctx, "publish "+queue, trace.WithSpanKind(trace.SpanKindProducer), tracing.QueueAttrs(queue))
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	tracing.Inject(ctx, headers)
+	err := r.ch().Publish("", queue, false, false, amqp.Publishing{
+		Headers:      headers,
+		ContentType:  d.ContentType,
+		DeliveryMode: d.DeliveryMode,
+		Timestamp:    d.Timestamp,
+		Body:         d.Body,
 	})
+	if err != nil {
+		metrics.QueueErrors.WithLabelValues("publish", queue).Inc()
+	}
+	tracing.End(span, err)
+	return err
 }
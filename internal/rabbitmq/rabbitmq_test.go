@@ -0,0 +1,75 @@
+package rabbitmq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// receive reads the next delivery of c, failing after a second.
+func receive(t *testing.T, c *consumer) (amqp.Delivery, bool) {
+	t.Helper()
+	select {
+	case d, ok := <-c.out:
+		return d, ok
+	case <-time.After(time.Second):
+		t.Fatal("no delivery")
+		return amqp.Delivery{}, false
+	}
+}
+
+func TestConsumerResumesOnNewChannel(t *testing.T) {
+	c := newConsumer("wpp:send")
+	first := make(chan amqp.Delivery, 1)
+	go c.run(first, make(chan struct{}))
+
+	first <- amqp.Delivery{DeliveryTag: 1}
+	if d, _ := receive(t, c); d.DeliveryTag != 1 {
+		t.Fatalf("delivery %d, want 1", d.DeliveryTag)
+	}
+	// the channel closed and watch reopened it
+	close(first)
+	second := make(chan amqp.Delivery, 1)
+	c.next <- second
+	second <- amqp.Delivery{DeliveryTag: 1, Body: []byte("after reconnect")}
+	if d, ok := receive(t, c); !ok || string(d.Body) != "after reconnect" {
+		t.Fatalf("delivery = %q, %v after the reconnect", d.Body, ok)
+	}
+
+	// deliveries received before the cancel are still handed out
+	second <- amqp.Delivery{DeliveryTag: 2}
+	c.cancel()
+	close(second)
+	if d, ok := receive(t, c); !ok || d.DeliveryTag != 2 {
+		t.Fatalf("delivery = %d, %v, want the prefetched one", d.DeliveryTag, ok)
+	}
+	if _, ok := receive(t, c); ok {
+		t.Error("deliveries still open after the cancel")
+	}
+}
+
+func TestConsumerEndsOnClose(t *testing.T) {
+	c := newConsumer("wpp:send")
+	msgs := make(chan amqp.Delivery)
+	done := make(chan struct{})
+	go c.run(msgs, done)
+
+	close(done)
+	close(msgs)
+	if _, ok := receive(t, c); ok {
+		t.Error("deliveries still open after Close")
+	}
+}
+
+func TestConsumerTagsAreUnique(t *testing.T) {
+	r := &RabbitMQ{}
+	seen := make(map[string]bool)
+	for range 3 {
+		tag := r.tag("wpp:send")
+		if seen[tag] {
+			t.Fatalf("tag %s handed out twice", tag)
+		}
+		seen[tag] = true
+	}
+}
@@ -0,0 +1,147 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/example/wpp-wave-bot/internal/tracing"
+)
+
+// replyType marks the replies among the messages of an RPC queue; the type
+// of requests is their method.
+const replyType = "reply"
+
+// ErrNotServing is returned by Call before Serve runs, as the reply would
+// have nowhere to go.
+var ErrNotServing = errors.New("rpc not serving")
+
+// Handler answers a request made with Call, returning the reply body.
+type Handler func(ctx context.Context, method string, body []byte) []byte
+
+// RPC makes requests to other instances and answers theirs through a direct
+// exchange, which routes requests by the name of the instance they are for
+// and replies by the name of the caller. It is safe for concurrent use.
+type RPC struct {
+	exchange string
+	name     string
+	mq       *RabbitMQ
+	// send publishes to the exchange; replaced in tests
+	send func(ctx context.Context, key string, msg amqp.Publishing) error
+
+	serving atomic.Bool
+	ids     atomic.Uint64
+	mu      sync.Mutex
+	pending map[string]chan []byte
+}
+
+// NewRPC declares the exchange and returns the RPC of the instance called
+// name.
+func (r *RabbitMQ) NewRPC(exchange, name string) (*RPC, error) {
+	if err := r.DeclareDirect(exchange); err != nil {
+		return nil, fmt.Errorf("declare %s: %w", exchange, err)
+	}
+	p := newRPC(exchange, name)
+	p.mq = r
+	p.send = func(ctx context.Context, key string, msg amqp.Publishing) error {
+		return r.publish(ctx, exchange, key, msg)
+	}
+	return p, nil
+}
+
+func newRPC(exchange, name string) *RPC {
+	return &RPC{exchange: exchange, name: name, pending: make(map[string]chan []byte)}
+}
+
+// Serve answers the requests to this instance with handle, passing it work,
+// and hands the replies to the waiting calls until ctx is done. Requests
+// are answered concurrently.
+func (p *RPC) Serve(ctx, work context.Context, handle Handler) error {
+	tag, msgs, err := p.mq.Subscribe(p.exchange, p.name)
+	if err != nil {
+		return fmt.Errorf("subscribe to %s: %w", p.exchange, err)
+	}
+	p.serving.Store(true)
+	defer p.serving.Store(false)
+	for {
+		select {
+		case <-ctx.Done():
+			if err := p.mq.Cancel(tag); err != nil {
+				log.Error().Err(err).Str("exchange", p.exchange).Msg("failed to stop serving")
+				return nil
+			}
+			for d := range msgs {
+				d.Ack(false)
+			}
+			return nil
+		case d, ok := <-msgs:
+			if !ok {
+				return fmt.Errorf("%s consumer closed", p.exchange)
+			}
+			d.Ack(false)
+			p.dispatch(work, handle, d)
+		}
+	}
+}
+
+// dispatch hands a reply to its call or answers a request.
+func (p *RPC) dispatch(ctx context.Context, handle Handler, d amqp.Delivery) {
+	if d.Type == replyType {
+		p.mu.Lock()
+		ch, ok := p.pending[d.CorrelationId]
+		p.mu.Unlock()
+		if ok {
+			// buffered and only ever sent one reply
+			ch <- d.Body
+		}
+		return
+	}
+	go func() {
+		ctx, span := tracing.Start(tracing.Extract(ctx, d.Headers), "serve "+d.Type,
+			trace.WithSpanKind(trace.SpanKindConsumer), tracing.QueueAttrs(p.name))
+		defer span.End()
+		body := handle(ctx, d.Type, d.Body)
+		err := p.send(ctx, d.ReplyTo, amqp.Publishing{Type: replyType, CorrelationId: d.CorrelationId, Body: body})
+		if err != nil {
+			log.Error().Err(err).Str("method", d.Type).Str("caller", d.ReplyTo).Msg("failed to reply")
+		}
+		tracing.End(span, err)
+	}()
+}
+
+// Call sends a request to the instance called to and waits for its reply
+// until ctx is done. Requests to instances that are gone are dropped, so
+// ctx should have a deadline.
+func (p *RPC) Call(ctx context.Context, to, method string, body []byte) ([]byte, error) {
+	if !p.serving.Load() {
+		return nil, ErrNotServing
+	}
+	id := p.name + "#" + strconv.FormatUint(p.ids.Add(1), 10)
+	reply := make(chan []byte, 1)
+	p.mu.Lock()
+	p.pending[id] = reply
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+	}()
+
+	err := p.send(ctx, to, amqp.Publishing{Type: method, ReplyTo: p.name, CorrelationId: id, Body: body})
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case body := <-reply:
+		return body, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,79 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// loopback is a sent message and its routing key.
+type loopback struct {
+	key string
+	msg amqp.Publishing
+}
+
+func TestCallGetsReplyOfOtherInstance(t *testing.T) {
+	p := newRPC("wpp:rpc", "bot-1")
+	p.serving.Store(true)
+	handle := func(_ context.Context, method string, body []byte) []byte {
+		return []byte(method + ":" + string(body))
+	}
+	var mu sync.Mutex
+	var sent []loopback
+	// every message comes back to p, as if bot-2 were p too
+	p.send = func(ctx context.Context, key string, msg amqp.Publishing) error {
+		mu.Lock()
+		sent = append(sent, loopback{key, msg})
+		mu.Unlock()
+		p.dispatch(ctx, handle, amqp.Delivery{Type: msg.Type, ReplyTo: msg.ReplyTo, CorrelationId: msg.CorrelationId, Body: msg.Body})
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	reply, err := p.Call(ctx, "bot-2", "send", []byte("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "send:hi" {
+		t.Errorf("reply = %q, want send:hi", reply)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) != 2 {
+		t.Fatalf("%d messages sent, want the request and the reply", len(sent))
+	}
+	if req := sent[0]; req.key != "bot-2" || req.msg.ReplyTo != "bot-1" || req.msg.Type != "send" {
+		t.Errorf("request to %s with reply_to %s and type %s", req.key, req.msg.ReplyTo, req.msg.Type)
+	}
+	if rep := sent[1]; rep.key != "bot-1" || rep.msg.Type != replyType || rep.msg.CorrelationId != sent[0].msg.CorrelationId {
+		t.Errorf("reply to %s of type %s for %s", rep.key, rep.msg.Type, rep.msg.CorrelationId)
+	}
+	if len(p.pending) != 0 {
+		t.Errorf("%d calls still pending", len(p.pending))
+	}
+}
+
+func TestCallWithoutReply(t *testing.T) {
+	p := newRPC("wpp:rpc", "bot-1")
+	p.send = func(context.Context, string, amqp.Publishing) error { return nil }
+
+	if _, err := p.Call(context.Background(), "bot-2", "send", nil); !errors.Is(err, ErrNotServing) {
+		t.Errorf("err = %v before serving, want ErrNotServing", err)
+	}
+
+	// the instance called is gone and the request dropped
+	p.serving.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.Call(ctx, "bot-2", "send", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the deadline", err)
+	}
+
+	// late replies are dropped
+	p.dispatch(context.Background(), nil, amqp.Delivery{Type: replyType, CorrelationId: "bot-1#2"})
+}
@@ -0,0 +1,81 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/example/wpp-wave-bot/internal/auth"
+)
+
+// authenticate resolves the API key or JWT sent in the "authorization"
+// ("Bearer <token>") or "x-api-key" metadata.
+func (s *Server) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var token string
+	if v := md.Get("x-api-key"); len(v) > 0 {
+		token = v[0]
+	}
+	if v := md.Get("authorization"); len(v) > 0 {
+		if bearer, ok := strings.CutPrefix(v[0], "Bearer "); ok {
+			token = bearer
+		}
+	}
+	if token == "" {
+		return nil, status.Error(codes.Unauthenticated, "missing credentials")
+	}
+
+	p, err := s.authn.Authenticate(ctx, token)
+	if errors.Is(err, auth.ErrInvalidKey) || errors.Is(err, auth.ErrInvalidToken) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to authenticate rpc")
+		return nil, status.Error(codes.Internal, "authentication failed")
+	}
+	return auth.WithPrincipal(ctx, p), nil
+}
+
+func (s *Server) unaryAuth(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) streamAuth(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authedStream carries the principal in the context of a stream.
+type authedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authedStream) Context() context.Context {
+	return s.ctx
+}
+
+// authorize checks the caller may act on the company.
+func authorize(ctx context.Context, companyID string) error {
+	if companyID == "" {
+		return status.Error(codes.InvalidArgument, "company_id is required")
+	}
+	p, ok := auth.FromContext(ctx)
+	if !ok || !p.CanAccess(companyID) {
+		return status.Error(codes.PermissionDenied, "access to company denied")
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/example/wpp-wave-bot/internal/auth"
+	"github.com/example/wpp-wave-bot/internal/whatsapp"
+	pb "github.com/example/wpp-wave-bot/pkg/pb/wppwavebotv1"
+)
+
+// Server implements the WhatsApp gRPC service on top of whatsapp.Service.
+type Server struct {
+	pb.UnimplementedWhatsAppServer
+
+	wa    *whatsapp.Service
+	authn *auth.Authenticator
+
+	grpcSrv *grpc.Server
+	// closing is closed on shutdown to end the streams.
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+// New creates a new Server bound to the WhatsApp service.
+func New(wa *whatsapp.Service, authn *auth.Authenticator) *Server {
+	s := &Server{wa: wa, authn: authn, closing: make(chan struct{})}
+	s.grpcSrv = grpc.NewServer(
+		grpc.UnaryInterceptor(s.unaryAuth),
+		grpc.StreamInterceptor(s.streamAuth),
+	)
+	pb.RegisterWhatsAppServer(s.grpcSrv, s)
+	return s
+}
+
+// Start serves gRPC on the given address.
+func (s *Server) Start(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.grpcSrv.Serve(lis)
+}
+
+// Shutdown ends the streams and stops the server once pending RPCs finish,
+// cutting them off when ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closeOnce.Do(func() { close(s.closing) })
+	done := make(chan struct{})
+	go func() {
+		s.grpcSrv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.grpcSrv.Stop()
+		return ctx.Err()
+	}
+}
+
+func (s *Server) Connect(ctx context.Context, req *pb.SessionRequest) (*pb.ConnectResponse, error) {
+	if err := authorize(ctx, req.GetCompanyId()); err != nil {
+		return nil, err
+	}
+	qr, err := s.wa.Connect(ctx, req.GetCompanyId())
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+	return &pb.ConnectResponse{Qr: qr}, nil
+}
+
+func (s *Server) Logout(ctx context.Context, req *pb.SessionRequest) (*pb.LogoutResponse, error) {
+	if err := authorize(ctx, req.GetCompanyId()); err != nil {
+		return nil, err
+	}
+	if err := s.wa.Logout(context.Background(), req.GetCompanyId()); err != nil {
+		return nil, statusError(ctx, err)
+	}
+	return &pb.LogoutResponse{}, nil
+}
+
+func (s *Server) GetSessionStatus(ctx context.Context, req *pb.SessionRequest) (*pb.SessionStatus, error) {
+	if err := authorize(ctx, req.GetCompanyId()); err != nil {
+		return nil, err
+	}
+	st, err := s.wa.Status(ctx, req.GetCompanyId())
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+	return sessionStatus(st), nil
+}
+
+func (s *Server) ListSessions(ctx context.Context, _ *pb.ListSessionsRequest) (*pb.ListSessionsResponse, error) {
+	list, err := s.wa.ListSessions(ctx)
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+	p, _ := auth.FromContext(ctx)
+	resp := &pb.ListSessionsResponse{}
+	for i := range list {
+		if p.CanAccess(list[i].CompanyID) {
+			resp.Sessions = append(resp.Sessions, sessionStatus(&list[i]))
+		}
+	}
+	return resp, nil
+}
+
+func (s *Server) StreamQR(req *pb.SessionRequest, stream pb.WhatsApp_StreamQRServer) error {
+	ctx := stream.Context()
+	companyID := req.GetCompanyId()
+	if err := authorize(ctx, companyID); err != nil {
+		return err
+	}
+
+	evts, unsubscribe := s.wa.Subscribe(companyID)
+	defer unsubscribe()
+
+	// Connect blocks until the QR codes are scanned or expire; the codes
+	// themselves arrive as session events.
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.wa.Connect(ctx, companyID)
+		done <- err
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-s.closing:
+			return status.Error(codes.Unavailable, "server shutting down")
+		case err := <-done:
+			if err != nil {
+				return statusError(ctx, err)
+			}
+			if st, err := s.wa.Status(ctx, companyID); err != nil || !st.LoggedIn {
+				return status.Error(codes.DeadlineExceeded, "qr codes expired before pairing")
+			}
+			return stream.Send(&pb.QREvent{Status: "connected"})
+		case evt := <-evts:
+			if evt.Name != "session" {
+				continue
+			}
+			var sess struct {
+				Status string `json:"status"`
+				Code   string `json:"code"`
+			}
+			if err := json.Unmarshal(evt.Payload, &sess); err != nil || sess.Status != "qr" {
+				continue
+			}
+			if err := stream.Send(&pb.QREvent{Status: sess.Status, Code: sess.Code}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Server) SendMessage(ctx context.Context, req *pb.SendMessageRequest) (*pb.SendMessageResponse, error) {
+	msg := &whatsapp.OutgoingMessage{
+		CompanyID:      req.GetCompanyId(),
+		Type:           req.GetType(),
+		To:             req.GetTo(),
+		Message:        req.GetMessage(),
+		MediaURL:       req.GetMediaUrl(),
+		Filename:       req.GetFilename(),
+		IdempotencyKey: req.GetIdempotencyKey(),
+		CorrelationID:  req.GetCorrelationId(),
+	}
+	if err := msg.Validate(); err != nil {
+		return nil, statusError(ctx, err)
+	}
+	if err := authorize(ctx, msg.CompanyID); err != nil {
+		return nil, err
+	}
+	res, err := s.wa.Send(ctx, msg)
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+	return &pb.SendMessageResponse{
+		Id:        res.ID,
+		MsgId:     res.MsgID,
+		Recipient: res.Recipient,
+		Status:    res.Status,
+		Timestamp: timestamppb.New(res.Timestamp),
+		Duplicate: res.Duplicate,
+	}, nil
+}
+
+func (s *Server) SubscribeEvents(req *pb.SubscribeEventsRequest, stream pb.WhatsApp_SubscribeEventsServer) error {
+	ctx := stream.Context()
+	if req.GetCompanyId() == "" {
+		if p, ok := auth.FromContext(ctx); !ok || !p.Admin {
+			return status.Error(codes.PermissionDenied, "company_id is required without admin scope")
+		}
+	} else if err := authorize(ctx, req.GetCompanyId()); err != nil {
+		return err
+	}
+
+	wanted := make(map[string]bool, len(req.GetEvents()))
+	for _, name := range req.GetEvents() {
+		wanted[name] = true
+	}
+
+	evts, unsubscribe := s.wa.Subscribe(req.GetCompanyId())
+	defer unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.closing:
+			return status.Error(codes.Unavailable, "server shutting down")
+		case evt := <-evts:
+			if len(wanted) > 0 && !wanted[evt.Name] {
+				continue
+			}
+			err := stream.Send(&pb.Event{
+				Event:     evt.Name,
+				CompanyId: evt.CompanyID,
+				Payload:   evt.Payload,
+				Time:      timestamppb.New(evt.Time),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func sessionStatus(st *whatsapp.SessionStatus) *pb.SessionStatus {
+	return &pb.SessionStatus{
+		CompanyId:          st.CompanyID,
+		Loaded:             st.Loaded,
+		Connected:          st.Connected,
+		LoggedIn:           st.LoggedIn,
+		Jid:                st.JID,
+		Paired:             st.Paired,
+		PushName:           st.PushName,
+		Platform:           st.Platform,
+		PairedAt:           timestamp(st.PairedAt),
+		LastConnectedAt:    timestamp(st.LastConnectedAt),
+		LastDisconnectedAt: timestamp(st.LastDisconnectedAt),
+		LastError:          st.LastError,
+		BannedUntil:        timestamp(st.BannedUntil),
+		Degraded:           st.Degraded,
+		ReconnectAttempts:  int32(st.ReconnectAttempts),
+		Owner:              st.Owner,
+		RateLimit:          rateLimitStatus(st.RateLimit),
+	}
+}
+
+func rateLimitStatus(rl *whatsapp.RateLimitStatus) *pb.RateLimitStatus {
+	if rl == nil {
+		return nil
+	}
+	return &pb.RateLimitStatus{Tokens: rl.Tokens, Waiting: int32(rl.Waiting)}
+}
+
+func timestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+// statusError maps errors of the service layer to gRPC status codes. Errors
+// without a mapping are logged and reported as internal errors.
+func statusError(ctx context.Context, err error) error {
+	var invalid *whatsapp.InvalidRecipientError
+	var validation *whatsapp.ValidationError
+	var limited *whatsapp.RateLimitError
+	switch {
+	case errors.As(err, &validation), errors.As(err, &invalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.As(err, &limited):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, whatsapp.ErrSessionNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, whatsapp.ErrNotPaired), errors.Is(err, whatsapp.ErrSessionBanned):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, whatsapp.ErrOwnerUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, whatsapp.ErrMediaDownload):
+		// The cause may hold the media URL and the remote server's answer.
+		method, _ := grpc.Method(ctx)
+		log.Warn().Err(err).Str("method", method).Msg("media download failed")
+		return status.Error(codes.Unavailable, whatsapp.ErrMediaDownload.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	default:
+		method, _ := grpc.Method(ctx)
+		log.Error().Err(err).Str("method", method).Msg("rpc failed")
+		return status.Error(codes.Internal, "internal error")
+	}
+}
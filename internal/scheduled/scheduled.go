@@ -0,0 +1,283 @@
+package scheduled
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/example/wpp-wave-bot/internal/db"
+)
+
+var (
+	// ErrNotFound is returned when a scheduled message doesn't exist for the
+	// company.
+	ErrNotFound = errors.New("scheduled message not found")
+	// ErrNotPending is returned when changing a message that was already
+	// dispatched, failed or cancelled.
+	ErrNotPending = errors.New("scheduled message already dispatched, failed or cancelled")
+)
+
+// PermanentError is returned by the dispatch function of Dispatch for a
+// message that can never be dispatched, such as one whose payload doesn't
+// decode. The message is marked failed instead of holding up the others.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// Statuses of a scheduled message.
+const (
+	StatusScheduled  = "scheduled"
+	StatusDispatched = "dispatched"
+	StatusFailed     = "failed"
+	StatusCancelled  = "cancelled"
+)
+
+// Message is an outgoing message waiting for its send time. Once dispatched
+// it is sent like any message from wpp:send, with Key as its idempotency
+// key, and MsgID and MessageStatus follow the sent message.
+type Message struct {
+	ID        int64     `json:"id"`
+	CompanyID string    `json:"company_id"`
+	SendAt    time.Time `json:"send_at"`
+	// Payload is the outgoing message as submitted.
+	Payload      json.RawMessage `json:"message"`
+	Status       string          `json:"status"`
+	Key          string          `json:"idempotency_key"`
+	DispatchedAt *time.Time      `json:"dispatched_at,omitempty"`
+	// Error is why a failed message couldn't be dispatched.
+	Error string `json:"error,omitempty"`
+	MsgID string `json:"msg_id,omitempty"`
+	// MessageStatus is the status of the sent message, e.g. sent or read.
+	MessageStatus string    `json:"message_status,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	// Duplicate is set when the idempotency key matched an earlier
+	// scheduled message and nothing was scheduled.
+	Duplicate bool `json:"duplicate"`
+}
+
+// Repository persists scheduled messages.
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+// NewRepository creates a new Repository.
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+// Messages without an idempotency key of their own are sent with a key
+// derived from their ID, so a message dispatched twice is only sent once.
+const baseColumns = `s.id, s.company_id, s.send_at, s.payload, s.status,
+        COALESCE(s.idempotency_key, 'scheduled:' || s.id), s.dispatched_at, COALESCE(s.error, ''),
+        s.created_at, s.updated_at`
+
+const columns = baseColumns + `, COALESCE(m.msg_id, ''), COALESCE(m.status, '')`
+
+// from joins the sent message of dispatched ones.
+const from = `scheduled_messages s
+        LEFT JOIN messages m ON s.status = 'dispatched' AND m.company_id = s.company_id
+            AND m.idempotency_key = COALESCE(s.idempotency_key, 'scheduled:' || s.id)`
+
+func scanBase(row pgx.Row, m *Message, extra ...any) error {
+	return row.Scan(append([]any{&m.ID, &m.CompanyID, &m.SendAt, &m.Payload, &m.Status, &m.Key,
+		&m.DispatchedAt, &m.Error, &m.CreatedAt, &m.UpdatedAt}, extra...)...)
+}
+
+func scanMessage(row pgx.Row, m *Message) error {
+	return scanBase(row, m, &m.MsgID, &m.MessageStatus)
+}
+
+// Create schedules payload for sendAt. When idempotencyKey matches an
+// earlier scheduled message of the company, that message is returned with
+// Duplicate set instead.
+func (r *Repository) Create(ctx context.Context, companyID string, sendAt time.Time, idempotencyKey string, payload []byte) (*Message, error) {
+	ctx = db.WithQueryName(ctx, "scheduled.create")
+	var id int64
+	err := r.db.QueryRow(ctx, `
+        INSERT INTO scheduled_messages (company_id, send_at, payload, idempotency_key)
+        VALUES ($1, $2, $3, NULLIF($4, ''))
+        ON CONFLICT (company_id, idempotency_key) WHERE idempotency_key IS NOT NULL DO NOTHING
+        RETURNING id
+    `, companyID, sendAt, payload, idempotencyKey).Scan(&id)
+	duplicate := errors.Is(err, pgx.ErrNoRows)
+	if err != nil && !duplicate {
+		return nil, err
+	}
+
+	var m Message
+	if duplicate {
+		err = scanMessage(r.db.QueryRow(ctx, `SELECT `+columns+` FROM `+from+`
+            WHERE s.company_id = $1 AND s.idempotency_key = $2`, companyID, idempotencyKey), &m)
+	} else {
+		err = scanMessage(r.db.QueryRow(ctx, `SELECT `+columns+` FROM `+from+` WHERE s.id = $1`, id), &m)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.Duplicate = duplicate
+	return &m, nil
+}
+
+// Get returns a scheduled message of the company.
+func (r *Repository) Get(ctx context.Context, companyID string, id int64) (*Message, error) {
+	ctx = db.WithQueryName(ctx, "scheduled.get")
+	var m Message
+	err := scanMessage(r.db.QueryRow(ctx, `SELECT `+columns+` FROM `+from+`
+        WHERE s.company_id = $1 AND s.id = $2`, companyID, id), &m)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// List returns the scheduled messages of a company by send time, optionally
+// only the ones in status.
+func (r *Repository) List(ctx context.Context, companyID, status string, limit int) ([]Message, error) {
+	ctx = db.WithQueryName(ctx, "scheduled.list")
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := r.db.Query(ctx, `SELECT `+columns+` FROM `+from+`
+        WHERE s.company_id = $1 AND ($2 = '' OR s.status = $2)
+        ORDER BY s.send_at, s.id
+        LIMIT $3`, companyID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Message{}
+	for rows.Next() {
+		var m Message
+		if err := scanMessage(rows, &m); err != nil {
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
+
+// Reschedule moves a message that wasn't dispatched yet to sendAt.
+func (r *Repository) Reschedule(ctx context.Context, companyID string, id int64, sendAt time.Time) (*Message, error) {
+	ctx = db.WithQueryName(ctx, "scheduled.reschedule")
+	tag, err := r.db.Exec(ctx, `
+        UPDATE scheduled_messages SET send_at = $3, updated_at = now()
+        WHERE company_id = $1 AND id = $2 AND status = 'scheduled'
+    `, companyID, id, sendAt)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, r.notPending(ctx, companyID, id)
+	}
+	return r.Get(ctx, companyID, id)
+}
+
+// Cancel keeps a message that wasn't dispatched yet from being sent.
+func (r *Repository) Cancel(ctx context.Context, companyID string, id int64) error {
+	ctx = db.WithQueryName(ctx, "scheduled.cancel")
+	tag, err := r.db.Exec(ctx, `
+        UPDATE scheduled_messages SET status = 'cancelled', updated_at = now()
+        WHERE company_id = $1 AND id = $2 AND status = 'scheduled'
+    `, companyID, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return r.notPending(ctx, companyID, id)
+	}
+	return nil
+}
+
+// notPending tells apart a message that doesn't exist from one that can't
+// change anymore.
+func (r *Repository) notPending(ctx context.Context, companyID string, id int64) error {
+	var exists bool
+	err := r.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM scheduled_messages WHERE company_id = $1 AND id = $2)`, companyID, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return ErrNotPending
+}
+
+// Dispatch passes up to limit due messages to dispatch and marks the ones
+// it accepted as dispatched, returning how many were handled. The messages
+// stay locked until then and are skipped by concurrent calls, so other
+// instances never dispatch them too. Messages failing with a PermanentError
+// are marked failed and the batch goes on, while any other error stops it
+// and leaves the rest for a later call.
+func (r *Repository) Dispatch(ctx context.Context, limit int, dispatch func(context.Context, Message) error) (int, error) {
+	ctx = db.WithQueryName(ctx, "scheduled.dispatch")
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `SELECT `+baseColumns+` FROM scheduled_messages s
+        WHERE s.status = 'scheduled' AND s.send_at <= now()
+        ORDER BY s.send_at, s.id
+        LIMIT $1
+        FOR UPDATE SKIP LOCKED`, limit)
+	if err != nil {
+		return 0, err
+	}
+	var due []Message
+	for rows.Next() {
+		var m Message
+		if err := scanBase(rows, &m); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	var dispatchErr error
+	for _, m := range due {
+		err := dispatch(ctx, m)
+		var permanent *PermanentError
+		if err != nil && !errors.As(err, &permanent) {
+			dispatchErr = err
+			break
+		}
+		if permanent != nil {
+			_, err = tx.Exec(ctx, `
+                UPDATE scheduled_messages SET status = 'failed', error = $2, updated_at = now()
+                WHERE id = $1
+            `, m.ID, permanent.Error())
+		} else {
+			_, err = tx.Exec(ctx, `
+                UPDATE scheduled_messages SET status = 'dispatched', dispatched_at = now(), updated_at = now()
+                WHERE id = $1
+            `, m.ID)
+		}
+		if err != nil {
+			return 0, err
+		}
+		n++
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return n, dispatchErr
+}
@@ -0,0 +1,143 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/example/wpp-wave-bot/internal/db"
+)
+
+// Session is the stored state of a paired WhatsApp device.
+type Session struct {
+	CompanyID      string
+	JID            string
+	PushName       string
+	Platform       string
+	PairedAt       *time.Time
+	ConnectedAt    *time.Time
+	DisconnectedAt *time.Time
+	LastError      string
+	// BannedUntil is set while WhatsApp temporarily bans the device.
+	BannedUntil *time.Time
+}
+
+// Repository persists paired sessions.
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+// NewRepository creates a new Repository.
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+const sessionColumns = `company_id, convert_from(data, 'UTF8'), COALESCE(push_name, ''), COALESCE(platform, ''),
+        paired_at, connected_at, disconnected_at, COALESCE(last_error, ''), banned_until`
+
+func scanSession(row pgx.Row, s *Session) error {
+	return row.Scan(&s.CompanyID, &s.JID, &s.PushName, &s.Platform, &s.PairedAt, &s.ConnectedAt, &s.DisconnectedAt, &s.LastError, &s.BannedUntil)
+}
+
+// Get returns the session of a company, or nil when it was never paired.
+func (r *Repository) Get(ctx context.Context, companyID string) (*Session, error) {
+	ctx = db.WithQueryName(ctx, "sessions.get")
+	var s Session
+	err := scanSession(r.db.QueryRow(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE company_id = $1`, companyID), &s)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// List returns every paired session.
+func (r *Repository) List(ctx context.Context) ([]Session, error) {
+	ctx = db.WithQueryName(ctx, "sessions.list")
+	rows, err := r.db.Query(ctx, `SELECT `+sessionColumns+` FROM sessions ORDER BY company_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Session{}
+	for rows.Next() {
+		var s Session
+		if err := scanSession(rows, &s); err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+// SavePaired stores the device a company just paired.
+func (r *Repository) SavePaired(ctx context.Context, companyID, jid, platform string) error {
+	ctx = db.WithQueryName(ctx, "sessions.save_paired")
+	_, err := r.db.Exec(ctx, `
+        INSERT INTO sessions (company_id, data, platform, paired_at)
+        VALUES ($1, $2, NULLIF($3, ''), now())
+        ON CONFLICT (company_id) DO UPDATE
+            SET data = EXCLUDED.data,
+                platform = EXCLUDED.platform,
+                paired_at = now(),
+                last_error = NULL,
+                updated_at = now()
+    `, companyID, []byte(jid), platform)
+	return err
+}
+
+// MarkConnected records a successful connection.
+func (r *Repository) MarkConnected(ctx context.Context, companyID, pushName, platform string) error {
+	ctx = db.WithQueryName(ctx, "sessions.mark_connected")
+	_, err := r.db.Exec(ctx, `
+        UPDATE sessions
+        SET connected_at = now(),
+            push_name = COALESCE(NULLIF($2, ''), push_name),
+            platform = COALESCE(NULLIF($3, ''), platform),
+            last_error = NULL,
+            banned_until = NULL,
+            updated_at = now()
+        WHERE company_id = $1
+    `, companyID, pushName, platform)
+	return err
+}
+
+// MarkDisconnected records a lost connection.
+func (r *Repository) MarkDisconnected(ctx context.Context, companyID string) error {
+	ctx = db.WithQueryName(ctx, "sessions.mark_disconnected")
+	_, err := r.db.Exec(ctx, `UPDATE sessions SET disconnected_at = now(), updated_at = now() WHERE company_id = $1`, companyID)
+	return err
+}
+
+// SetError records the last error of a session.
+func (r *Repository) SetError(ctx context.Context, companyID, msg string) error {
+	ctx = db.WithQueryName(ctx, "sessions.set_error")
+	_, err := r.db.Exec(ctx, `UPDATE sessions SET last_error = $2, updated_at = now() WHERE company_id = $1`, companyID, msg)
+	return err
+}
+
+// SetBanned records a temporary ban lasting until the given time.
+func (r *Repository) SetBanned(ctx context.Context, companyID string, until time.Time, msg string) error {
+	ctx = db.WithQueryName(ctx, "sessions.set_banned")
+	_, err := r.db.Exec(ctx, `
+        UPDATE sessions
+        SET banned_until = $2,
+            last_error = $3,
+            updated_at = now()
+        WHERE company_id = $1
+    `, companyID, until, msg)
+	return err
+}
+
+// Delete removes the session of a company.
+func (r *Repository) Delete(ctx context.Context, companyID string) error {
+	ctx = db.WithQueryName(ctx, "sessions.delete")
+	_, err := r.db.Exec(ctx, `DELETE FROM sessions WHERE company_id = $1`, companyID)
+	return err
+}
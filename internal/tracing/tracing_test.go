@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// record installs a tracer provider recording every span and the propagators
+// of Setup, restoring the previous globals when the test ends.
+func record(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+
+	if _, err := Setup(context.Background(), Config{}); err != nil {
+		t.Fatal(err)
+	}
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	return rec
+}
+
+func TestPropagationAcrossRabbitMQ(t *testing.T) {
+	rec := record(t)
+
+	ctx, publish := Start(context.Background(), "publish wpp:send", trace.WithSpanKind(trace.SpanKindProducer), QueueAttrs("wpp:send"))
+	msg := amqp.Publishing{Headers: amqp.Table{}, Body: []byte(`{}`)}
+	Inject(ctx, msg.Headers)
+	publish.End()
+	if _, ok := msg.Headers["traceparent"].(string); !ok {
+		t.Fatalf("headers = %v, want a traceparent", msg.Headers)
+	}
+
+	d := amqp.Delivery{Headers: msg.Headers, Body: msg.Body, RoutingKey: "wpp:send"}
+	_, consume := Start(Extract(context.Background(), d.Headers), "consume wpp:send", trace.WithSpanKind(trace.SpanKindConsumer), QueueAttrs(d.RoutingKey))
+	consume.End()
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(spans))
+	}
+	producer, consumer := spans[0], spans[1]
+	if consumer.SpanContext().TraceID() != producer.SpanContext().TraceID() {
+		t.Errorf("consumer trace %s, want the publisher's %s", consumer.SpanContext().TraceID(), producer.SpanContext().TraceID())
+	}
+	if consumer.Parent().SpanID() != producer.SpanContext().SpanID() || !consumer.Parent().IsRemote() {
+		t.Errorf("consumer parent = %s (remote %v), want the publish span %s", consumer.Parent().SpanID(), consumer.Parent().IsRemote(), producer.SpanContext().SpanID())
+	}
+	if consumer.SpanKind() != trace.SpanKindConsumer || producer.SpanKind() != trace.SpanKindProducer {
+		t.Errorf("span kinds = %s, %s", producer.SpanKind(), consumer.SpanKind())
+	}
+}
+
+func TestExtractWithoutHeadersStartsNewTrace(t *testing.T) {
+	rec := record(t)
+
+	_, span := Start(Extract(context.Background(), amqp.Table{"x-retries": int32(2)}), "consume wpp:send")
+	span.End()
+
+	spans := rec.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(spans))
+	}
+	if spans[0].Parent().IsValid() {
+		t.Errorf("span has parent %s, want a root span", spans[0].Parent().SpanID())
+	}
+}
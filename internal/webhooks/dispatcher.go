@@ -0,0 +1,270 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/example/wpp-wave-bot/internal/metrics"
+)
+
+// Config controls delivery behaviour of the Dispatcher.
+type Config struct {
+	// MaxAttempts is how many times a single event is tried before giving up.
+	MaxAttempts int
+	// MaxFailures is how many consecutive failed events disable a webhook.
+	MaxFailures int
+	// Timeout bounds every HTTP request.
+	Timeout time.Duration
+	// Backoff is the delay before the first retry, doubled on each attempt.
+	Backoff time.Duration
+	// Workers is how many deliveries run at once.
+	Workers int
+	// QueueSize bounds the events and retries waiting for a worker. Events
+	// arriving while the queue is full are dropped.
+	QueueSize int
+}
+
+// Dispatcher delivers events to the webhooks subscribed to them. Every request
+// is signed with the webhook secret:
+//
+//	X-Webhook-Signature: sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+//
+// where timestamp is the unix time sent in X-Webhook-Timestamp.
+//
+// Events are queued and delivered by a fixed pool of workers, and failed
+// deliveries are queued again once their backoff elapsed, so a burst of
+// events or a slow receiver never holds more than Config.Workers requests.
+type Dispatcher struct {
+	repo   *Repository
+	cfg    Config
+	client *http.Client
+	wg     sync.WaitGroup
+
+	// mu guards closed and sending to queue, which is closed by Wait.
+	mu     sync.Mutex
+	closed bool
+	queue  chan job
+}
+
+// job is an event to deliver to the webhooks subscribed to it, or a retry of
+// its delivery to one of them.
+type job struct {
+	companyID string
+	event     string
+	body      []byte
+	// hookID and attempt are set for retries
+	hookID  int64
+	attempt int
+}
+
+// NewDispatcher creates a Dispatcher, filling unset config with defaults.
+func NewDispatcher(repo *Repository, cfg Config) *Dispatcher {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.MaxFailures <= 0 {
+		cfg.MaxFailures = 10
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 8
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	d := &Dispatcher{
+		repo:   repo,
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout, Transport: transport()},
+		queue:  make(chan job, cfg.QueueSize),
+	}
+	d.wg.Add(cfg.Workers)
+	for range cfg.Workers {
+		go d.work()
+	}
+	return d
+}
+
+// Dispatch queues body for delivery to every webhook of the company
+// subscribed to event. It never blocks: the event is dropped when the queue
+// is full.
+func (d *Dispatcher) Dispatch(companyID, event string, body []byte) {
+	if !d.enqueue(job{companyID: companyID, event: event, body: body}) {
+		log.Warn().Str("company_id", companyID).Str("event", event).Msg("webhook queue full or closed, dropping event")
+	}
+}
+
+// enqueue queues j unless the queue is full or closed.
+func (d *Dispatcher) enqueue(j job) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return false
+	}
+	select {
+	case d.queue <- j:
+		return true
+	default:
+		metrics.WebhookDropped.WithLabelValues(j.companyID).Inc()
+		return false
+	}
+}
+
+// Wait stops accepting events and blocks until the queued deliveries and
+// in-flight requests finish or ctx is done. Deliveries waiting for a retry
+// give up.
+func (d *Dispatcher) Wait(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// work delivers queued jobs until the queue is closed and drained.
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for j := range d.queue {
+		if j.hookID != 0 {
+			d.retry(j)
+			continue
+		}
+		hooks, err := d.repo.Subscribed(context.Background(), j.companyID, j.event)
+		if err != nil {
+			log.Error().Err(err).Str("company_id", j.companyID).Msg("failed to load webhooks")
+			continue
+		}
+		for _, h := range hooks {
+			d.deliver(h, j.event, j.body, 1)
+		}
+	}
+}
+
+// retry delivers j again to its webhook, which is looked up again so retries
+// stop once it was deleted, disabled or unsubscribed from the event.
+func (d *Dispatcher) retry(j job) {
+	h, err := d.repo.Subscriber(context.Background(), j.hookID, j.event)
+	if errors.Is(err, ErrNotFound) {
+		log.Info().Int64("webhook_id", j.hookID).Str("company_id", j.companyID).Str("event", j.event).Msg("webhook removed or disabled, retries abandoned")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Int64("webhook_id", j.hookID).Msg("failed to load webhook")
+		return
+	}
+	d.deliver(*h, j.event, j.body, j.attempt)
+}
+
+// deliver makes one delivery attempt, queueing the next one after its
+// backoff when it fails.
+func (d *Dispatcher) deliver(h Webhook, event string, body []byte, attempt int) {
+	start := time.Now()
+	status, err := d.post(h, event, body)
+	delivery := Delivery{
+		WebhookID:  h.ID,
+		Event:      event,
+		Payload:    body,
+		Attempt:    attempt,
+		StatusCode: status,
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	if err := d.repo.LogDelivery(context.Background(), delivery); err != nil {
+		log.Error().Err(err).Int64("webhook_id", h.ID).Msg("failed to log webhook delivery")
+	}
+	if err != nil && attempt < d.cfg.MaxAttempts {
+		retry := job{companyID: h.CompanyID, event: event, body: body, hookID: h.ID, attempt: attempt + 1}
+		time.AfterFunc(d.cfg.Backoff<<(attempt-1), func() {
+			if !d.enqueue(retry) {
+				log.Warn().Int64("webhook_id", h.ID).Str("company_id", h.CompanyID).Str("event", event).Msg("webhook queue full or closed, retries abandoned")
+			}
+		})
+		return
+	}
+	ok := err == nil
+
+	disabled, err := d.repo.RecordResult(context.Background(), h.ID, ok, d.cfg.MaxFailures)
+	if err != nil {
+		log.Error().Err(err).Int64("webhook_id", h.ID).Msg("failed to record webhook result")
+	}
+	if !ok {
+		log.Warn().Int64("webhook_id", h.ID).Str("company_id", h.CompanyID).Str("event", event).Msg("webhook delivery failed")
+	}
+	if disabled {
+		log.Warn().Int64("webhook_id", h.ID).Str("company_id", h.CompanyID).Msg("webhook disabled after repeated failures")
+	}
+}
+
+func (d *Dispatcher) post(h Webhook, event string, body []byte) (int, error) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Webhook-Timestamp", ts)
+	req.Header.Set("X-Webhook-Signature", "sha256="+Sign(h.Secret, ts, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of timestamp and body, which
+// receivers can recompute to verify a delivery.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature, the X-Webhook-Signature header of a
+// delivery, matches timestamp and body.
+func Verify(secret, timestamp, signature string, body []byte) bool {
+	sig, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(Sign(secret, timestamp, body)))
+}
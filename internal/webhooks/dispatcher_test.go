@@ -0,0 +1,90 @@
+package webhooks
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	got := Sign("secret", "1700000000", []byte(`{"event":"message.sent"}`))
+	want := "53530673a71f70e458e0afadf8f2f8ebf94d8bc5518360f825d87a0694b40f7c"
+	if got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"event":"message.sent"}`)
+	valid := "sha256=" + Sign("secret", "1700000000", body)
+	tests := []struct {
+		name, secret, timestamp, signature string
+		body                               []byte
+		want                               bool
+	}{
+		{"valid", "secret", "1700000000", valid, body, true},
+		{"wrong secret", "other", "1700000000", valid, body, false},
+		{"wrong timestamp", "secret", "1700000001", valid, body, false},
+		{"tampered body", "secret", "1700000000", valid, []byte(`{"event":"message.failed"}`), false},
+		{"missing prefix", "secret", "1700000000", Sign("secret", "1700000000", body), body, false},
+		{"empty", "secret", "1700000000", "", body, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Verify(tt.secret, tt.timestamp, tt.signature, tt.body); got != tt.want {
+				t.Errorf("Verify = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostSignsRequest(t *testing.T) {
+	body := []byte(`{"event":"session","company_id":"empresa-123"}`)
+	var verified bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := io.ReadAll(r.Body)
+		verified = r.Header.Get("X-Webhook-Event") == "session" &&
+			r.Header.Get("Content-Type") == "application/json" &&
+			Verify("s3cr3t", r.Header.Get("X-Webhook-Timestamp"), r.Header.Get("X-Webhook-Signature"), got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	d := &Dispatcher{client: srv.Client()}
+	status, err := d.post(Webhook{URL: srv.URL, Secret: "s3cr3t"}, "session", body)
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("post = %d, %v", status, err)
+	}
+	if !verified {
+		t.Error("receiver couldn't verify the delivery")
+	}
+}
+
+func TestPostFailsOnErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	d := &Dispatcher{client: srv.Client()}
+	status, err := d.post(Webhook{URL: srv.URL, Secret: "s3cr3t"}, "session", []byte(`{}`))
+	if err == nil || status != http.StatusBadGateway {
+		t.Errorf("post = %d, %v, want 502 and an error", status, err)
+	}
+}
+
+func TestEnqueueDropsWhenFull(t *testing.T) {
+	d := &Dispatcher{queue: make(chan job, 1)}
+	if !d.enqueue(job{companyID: "empresa-123", event: "session"}) {
+		t.Fatal("first event wasn't queued")
+	}
+	if d.enqueue(job{companyID: "empresa-123", event: "session"}) {
+		t.Error("event queued beyond the queue size")
+	}
+	d.closed = true
+	<-d.queue
+	if d.enqueue(job{companyID: "empresa-123", event: "session"}) {
+		t.Error("event queued after Wait")
+	}
+}
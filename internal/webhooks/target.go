@@ -0,0 +1,80 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenTarget is returned for webhook URLs pointing at loopback,
+// link-local or private addresses, which would let a company reach services
+// on our own network.
+var ErrForbiddenTarget = errors.New("webhook url must point to a public address")
+
+// CheckTarget validates that rawURL is an http(s) URL whose host resolves only
+// to public addresses.
+func CheckTarget(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("url must be an http(s) URL")
+	}
+	if ip, err := netip.ParseAddr(u.Hostname()); err == nil {
+		if !publicAddr(ip) {
+			return ErrForbiddenTarget
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("resolve %s: %w", u.Hostname(), err)
+	}
+	for _, ip := range addrs {
+		if !publicAddr(ip) {
+			return ErrForbiddenTarget
+		}
+	}
+	return nil
+}
+
+// publicAddr reports whether ip is routable on the internet.
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsValid() &&
+		!ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified()
+}
+
+// transport refuses to connect to non-public addresses, so a webhook host
+// resolving elsewhere after it was registered, or redirecting there, can't
+// reach them either.
+func transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddr(addr.Addr()) {
+				return ErrForbiddenTarget
+			}
+			return nil
+		},
+	}
+	t.DialContext = dialer.DialContext
+	t.Proxy = nil
+	return t
+}
@@ -0,0 +1,68 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckTarget(t *testing.T) {
+	tests := []struct {
+		url  string
+		want error
+	}{
+		{"https://93.184.216.34/hook", nil},
+		{"http://[2606:2800:220:1:248:1893:25c8:1946]:8080/hook", nil},
+		{"http://127.0.0.1:8080/hook", ErrForbiddenTarget},
+		{"http://[::1]/hook", ErrForbiddenTarget},
+		{"http://10.0.0.7/hook", ErrForbiddenTarget},
+		{"http://172.16.3.4/hook", ErrForbiddenTarget},
+		{"http://192.168.0.10/hook", ErrForbiddenTarget},
+		{"http://169.254.169.254/latest/meta-data", ErrForbiddenTarget},
+		{"http://[fe80::1]/hook", ErrForbiddenTarget},
+		{"http://[fd00::1]/hook", ErrForbiddenTarget},
+		{"http://[::ffff:127.0.0.1]/hook", ErrForbiddenTarget},
+		{"http://0.0.0.0/hook", ErrForbiddenTarget},
+		{"http://localhost/hook", ErrForbiddenTarget},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if err := CheckTarget(context.Background(), tt.url); !errors.Is(err, tt.want) {
+				t.Errorf("CheckTarget = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	for _, url := range []string{"ftp://93.184.216.34/hook", "/hook", "http://"} {
+		if err := CheckTarget(context.Background(), url); err == nil || errors.Is(err, ErrForbiddenTarget) {
+			t.Errorf("CheckTarget(%q) = %v, want an invalid URL error", url, err)
+		}
+	}
+}
+
+func TestTransportRefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("delivery reached a loopback receiver")
+	}))
+	defer srv.Close()
+
+	d := &Dispatcher{client: &http.Client{Transport: transport()}}
+	if _, err := d.post(Webhook{URL: srv.URL, Secret: "s3cr3t"}, "session", []byte(`{}`)); !errors.Is(err, ErrForbiddenTarget) {
+		t.Errorf("post = %v, want ErrForbiddenTarget", err)
+	}
+}
+
+func TestValidEvent(t *testing.T) {
+	for _, event := range Events {
+		if !ValidEvent(event) {
+			t.Errorf("ValidEvent(%q) = false", event)
+		}
+	}
+	for _, event := range []string{"", "presence", "message.*", "Session"} {
+		if ValidEvent(event) {
+			t.Errorf("ValidEvent(%q) = true", event)
+		}
+	}
+}
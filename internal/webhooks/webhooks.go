@@ -0,0 +1,266 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/example/wpp-wave-bot/internal/db"
+)
+
+// ErrNotFound is returned when a webhook doesn't exist for the company.
+var ErrNotFound = errors.New("webhook not found")
+
+// Events are the events webhooks can subscribe to.
+var Events = []string{
+	"message.received",
+	"message.status",
+	"message.sent",
+	"message.failed",
+	"message.scheduled",
+	"session",
+	"session.degraded",
+}
+
+// ValidEvent reports whether event is one of Events.
+func ValidEvent(event string) bool {
+	return slices.Contains(Events, event)
+}
+
+// Webhook is an HTTP endpoint subscribed to a company's events. An empty
+// Events list subscribes to every event.
+type Webhook struct {
+	ID           int64     `json:"id"`
+	CompanyID    string    `json:"company_id"`
+	URL          string    `json:"url"`
+	Secret       string    `json:"secret,omitempty"`
+	Events       []string  `json:"events"`
+	Enabled      bool      `json:"enabled"`
+	FailureCount int       `json:"failure_count"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Delivery is a logged delivery attempt.
+type Delivery struct {
+	ID         int64           `json:"id"`
+	WebhookID  int64           `json:"webhook_id"`
+	Event      string          `json:"event"`
+	Payload    json.RawMessage `json:"payload"`
+	Attempt    int             `json:"attempt"`
+	StatusCode int             `json:"status_code,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	DurationMS int64           `json:"duration_ms"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// Repository handles webhook persistence.
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+// NewRepository creates a new Repository.
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+const webhookColumns = `id, company_id, url, secret, events, enabled, failure_count, created_at, updated_at`
+
+// Create registers a webhook with a freshly generated signing secret.
+func (r *Repository) Create(ctx context.Context, companyID, url string, events []string) (*Webhook, error) {
+	ctx = db.WithQueryName(ctx, "webhooks.create")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	if events == nil {
+		events = []string{}
+	}
+	var w Webhook
+	err := scanWebhook(r.db.QueryRow(ctx, `
+        INSERT INTO webhooks (company_id, url, secret, events)
+        VALUES ($1, $2, $3, $4)
+        RETURNING `+webhookColumns,
+		companyID, url, hex.EncodeToString(secret), events,
+	), &w)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// List returns the webhooks of a company without their secrets.
+func (r *Repository) List(ctx context.Context, companyID string) ([]Webhook, error) {
+	ctx = db.WithQueryName(ctx, "webhooks.list")
+	rows, err := r.db.Query(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE company_id = $1 ORDER BY id`, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []Webhook{}
+	for rows.Next() {
+		var w Webhook
+		if err := scanWebhook(rows, &w); err != nil {
+			return nil, err
+		}
+		w.Secret = ""
+		hooks = append(hooks, w)
+	}
+	return hooks, rows.Err()
+}
+
+// Subscribed returns the enabled webhooks of a company listening to event.
+func (r *Repository) Subscribed(ctx context.Context, companyID, event string) ([]Webhook, error) {
+	ctx = db.WithQueryName(ctx, "webhooks.subscribed")
+	rows, err := r.db.Query(ctx, `
+        SELECT `+webhookColumns+`
+        FROM webhooks
+        WHERE company_id = $1 AND enabled AND (cardinality(events) = 0 OR $2 = ANY(events))
+    `, companyID, event)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []Webhook
+	for rows.Next() {
+		var w Webhook
+		if err := scanWebhook(rows, &w); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, w)
+	}
+	return hooks, rows.Err()
+}
+
+// Subscriber returns the webhook with id if it is still enabled and listening
+// to event, or ErrNotFound.
+func (r *Repository) Subscriber(ctx context.Context, id int64, event string) (*Webhook, error) {
+	ctx = db.WithQueryName(ctx, "webhooks.subscriber")
+	var w Webhook
+	err := scanWebhook(r.db.QueryRow(ctx, `
+        SELECT `+webhookColumns+`
+        FROM webhooks
+        WHERE id = $1 AND enabled AND (cardinality(events) = 0 OR $2 = ANY(events))
+    `, id, event), &w)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// Delete removes a webhook of a company along with its delivery log.
+func (r *Repository) Delete(ctx context.Context, companyID string, id int64) error {
+	ctx = db.WithQueryName(ctx, "webhooks.delete")
+	tag, err := r.db.Exec(ctx, `DELETE FROM webhooks WHERE company_id = $1 AND id = $2`, companyID, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Enable re-enables a webhook and resets its failure count.
+func (r *Repository) Enable(ctx context.Context, companyID string, id int64) error {
+	ctx = db.WithQueryName(ctx, "webhooks.enable")
+	tag, err := r.db.Exec(ctx, `
+        UPDATE webhooks SET enabled = true, failure_count = 0, updated_at = now()
+        WHERE company_id = $1 AND id = $2
+    `, companyID, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RecordResult tracks consecutive failed deliveries of a webhook, disabling
+// it once maxFailures is reached. A successful delivery resets the count.
+// It reports whether the webhook ended up disabled.
+func (r *Repository) RecordResult(ctx context.Context, id int64, ok bool, maxFailures int) (bool, error) {
+	ctx = db.WithQueryName(ctx, "webhooks.record_result")
+	if ok {
+		_, err := r.db.Exec(ctx, `UPDATE webhooks SET failure_count = 0 WHERE id = $1 AND failure_count > 0`, id)
+		return false, err
+	}
+	var enabled bool
+	err := r.db.QueryRow(ctx, `
+        UPDATE webhooks
+        SET failure_count = failure_count + 1,
+            enabled = enabled AND failure_count + 1 < $2,
+            updated_at = now()
+        WHERE id = $1
+        RETURNING enabled
+    `, id, maxFailures).Scan(&enabled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return !enabled, err
+}
+
+// LogDelivery stores a delivery attempt.
+func (r *Repository) LogDelivery(ctx context.Context, d Delivery) error {
+	ctx = db.WithQueryName(ctx, "webhooks.log_delivery")
+	_, err := r.db.Exec(ctx, `
+        INSERT INTO webhook_deliveries (webhook_id, event, payload, attempt, status_code, error, duration_ms)
+        VALUES ($1, $2, $3, $4, NULLIF($5, 0), NULLIF($6, ''), $7)
+    `, d.WebhookID, d.Event, string(d.Payload), d.Attempt, d.StatusCode, d.Error, d.DurationMS)
+	return err
+}
+
+// Deliveries returns the latest delivery attempts of a company's webhook.
+func (r *Repository) Deliveries(ctx context.Context, companyID string, webhookID int64, limit int) ([]Delivery, error) {
+	ctx = db.WithQueryName(ctx, "webhooks.deliveries")
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := r.db.Query(ctx, `
+        SELECT d.id, d.webhook_id, d.event, d.payload, d.attempt, COALESCE(d.status_code, 0),
+               COALESCE(d.error, ''), COALESCE(d.duration_ms, 0), d.created_at
+        FROM webhook_deliveries d
+        JOIN webhooks w ON w.id = d.webhook_id
+        WHERE w.company_id = $1 AND d.webhook_id = $2
+        ORDER BY d.id DESC
+        LIMIT $3
+    `, companyID, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		var payload []byte
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &payload, &d.Attempt, &d.StatusCode,
+			&d.Error, &d.DurationMS, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		d.Payload = payload
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanWebhook(row scanner, w *Webhook) error {
+	return row.Scan(&w.ID, &w.CompanyID, &w.URL, &w.Secret, &w.Events, &w.Enabled, &w.FailureCount, &w.CreatedAt, &w.UpdatedAt)
+}
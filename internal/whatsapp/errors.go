@@ -0,0 +1,73 @@
+package whatsapp
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+var (
+	// ErrSessionNotFound is returned when a company has no loaded session.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrNotPaired is returned when a session hasn't completed QR pairing.
+	ErrNotPaired = errors.New("session not paired")
+	// ErrSessionBanned is returned while WhatsApp temporarily bans the
+	// session's device.
+	ErrSessionBanned = errors.New("session temporarily banned")
+	// ErrSessionOwnedElsewhere is returned when another instance holds the
+	// lease of the company's session.
+	ErrSessionOwnedElsewhere = errors.New("session owned by another instance")
+	// ErrOwnerUnavailable is returned when a call forwarded to the instance
+	// owning the session got no answer, or the lease moved meanwhile.
+	ErrOwnerUnavailable = errors.New("session owner unavailable")
+	// ErrMediaDownload wraps failures fetching the media of a message.
+	ErrMediaDownload = errors.New("media download failed")
+)
+
+// ValidationError reports an invalid field of an OutgoingMessage.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// maxMessageLength is the longest text WhatsApp accepts in a message.
+const maxMessageLength = 65536
+
+// Validate checks the message is complete before any work is done on it.
+func (m *OutgoingMessage) Validate() error {
+	if m.CompanyID == "" {
+		return &ValidationError{Field: "company_id", Message: "is required"}
+	}
+	if m.To == "" {
+		return &ValidationError{Field: "to", Message: "is required"}
+	}
+	switch m.Type {
+	case "text":
+		if m.Message == "" {
+			return &ValidationError{Field: "message", Message: "is required for text messages"}
+		}
+	case "image", "audio", "document":
+		u, err := url.Parse(m.MediaURL)
+		if m.MediaURL == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &ValidationError{Field: "media_url", Message: "must be an http(s) URL for " + m.Type + " messages"}
+		}
+	case "":
+		return &ValidationError{Field: "type", Message: "is required"}
+	default:
+		return &ValidationError{Field: "type", Message: "must be one of text, image, audio, document"}
+	}
+	if len(m.Message) > maxMessageLength {
+		return &ValidationError{Field: "message", Message: "is too long"}
+	}
+	if len(m.IdempotencyKey) > 255 {
+		return &ValidationError{Field: "idempotency_key", Message: "must be at most 255 characters"}
+	}
+	if len(m.CorrelationID) > 255 {
+		return &ValidationError{Field: "correlation_id", Message: "must be at most 255 characters"}
+	}
+	return nil
+}
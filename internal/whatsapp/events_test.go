@@ -0,0 +1,47 @@
+package whatsapp
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/example/wpp-wave-bot/internal/events"
+)
+
+func TestRelayDeliversEventsOfOtherInstances(t *testing.T) {
+	s := &Service{cfg: Config{InstanceID: "bot-1"}, events: events.NewHub()}
+	ch, unsubscribe := s.Subscribe("empresa-123")
+	defer unsubscribe()
+
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	body, err := json.Marshal(events.Event{
+		Name:      "message.received",
+		CompanyID: "empresa-123",
+		Payload:   json.RawMessage(`{"msg_id":"3EB0"}`),
+		Time:      at,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// our own events reached the subscribers when they happened
+	s.relay(amqp.Delivery{AppId: "bot-1", Body: body})
+	s.relay(amqp.Delivery{AppId: "bot-2", Body: []byte(`{"event":`)})
+	s.relay(amqp.Delivery{AppId: "bot-2", Body: body})
+
+	select {
+	case evt := <-ch:
+		if evt.Name != "message.received" || !evt.Time.Equal(at) || string(evt.Payload) != `{"msg_id":"3EB0"}` {
+			t.Errorf("relayed event = %+v", evt)
+		}
+	default:
+		t.Fatal("event of another instance not relayed")
+	}
+	select {
+	case evt := <-ch:
+		t.Errorf("unexpected event %+v", evt)
+	default:
+	}
+}
@@ -0,0 +1,188 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Calls that need the client of a session owned by another instance are
+// forwarded to the owner over the wpp:rpc exchange and answered with its
+// result, so callers may use any instance.
+
+// rpcExchange routes forwarded calls to the instance owning the session.
+const rpcExchange = "wpp:rpc"
+
+// forwardTimeout bounds a forwarded call, including the owner's wait for
+// the rate limits.
+const forwardTimeout = time.Minute
+
+// Methods of the forwarded calls.
+const (
+	methodSend              = "send"
+	methodConnect           = "connect"
+	methodCheckNumbers      = "check_numbers"
+	methodSubscribePresence = "subscribe_presence"
+	methodLogout            = "logout"
+)
+
+// forwardRequest holds the arguments of a forwarded call.
+type forwardRequest struct {
+	CompanyID string           `json:"company_id"`
+	Message   *OutgoingMessage `json:"message,omitempty"`
+	Numbers   []string         `json:"numbers,omitempty"`
+	To        string           `json:"to,omitempty"`
+}
+
+// forwardReply holds the result of a forwarded call or its error.
+type forwardReply struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *remoteError    `json:"error,omitempty"`
+}
+
+// remoteError carries an error of the owner back to the caller, keeping
+// what the API needs to report it.
+type remoteError struct {
+	Kind       string        `json:"kind"`
+	Message    string        `json:"message"`
+	Field      string        `json:"field,omitempty"`
+	To         string        `json:"to,omitempty"`
+	Reason     string        `json:"reason,omitempty"`
+	Scope      string        `json:"scope,omitempty"`
+	RetryAfter time.Duration `json:"retry_after,omitempty"`
+}
+
+// sentinels are the errors forwarded by kind.
+var sentinels = map[string]error{
+	"session_not_found":       ErrSessionNotFound,
+	"not_paired":              ErrNotPaired,
+	"session_banned":          ErrSessionBanned,
+	"session_owned_elsewhere": ErrSessionOwnedElsewhere,
+	"media_download":          ErrMediaDownload,
+}
+
+func encodeError(err error) *remoteError {
+	var validation *ValidationError
+	var invalid *InvalidRecipientError
+	var limited *RateLimitError
+	switch {
+	case errors.As(err, &validation):
+		return &remoteError{Kind: "validation", Message: validation.Message, Field: validation.Field}
+	case errors.As(err, &invalid):
+		return &remoteError{Kind: "invalid_recipient", To: invalid.To, Reason: invalid.Reason}
+	case errors.As(err, &limited):
+		return &remoteError{Kind: "rate_limited", Scope: limited.Scope, RetryAfter: limited.RetryAfter}
+	}
+	for kind, sentinel := range sentinels {
+		if errors.Is(err, sentinel) {
+			return &remoteError{Kind: kind, Message: err.Error()}
+		}
+	}
+	return &remoteError{Message: err.Error()}
+}
+
+func (e *remoteError) err() error {
+	switch e.Kind {
+	case "validation":
+		return &ValidationError{Field: e.Field, Message: e.Message}
+	case "invalid_recipient":
+		return &InvalidRecipientError{To: e.To, Reason: e.Reason}
+	case "rate_limited":
+		return &RateLimitError{Scope: e.Scope, RetryAfter: e.RetryAfter}
+	}
+	if sentinel, ok := sentinels[e.Kind]; ok {
+		return &forwardedError{msg: e.Message, err: sentinel}
+	}
+	return errors.New(e.Message)
+}
+
+// forwardedError keeps the message of an owner's error while matching its
+// sentinel.
+type forwardedError struct {
+	msg string
+	err error
+}
+
+func (e *forwardedError) Error() string { return e.msg }
+func (e *forwardedError) Unwrap() error { return e.err }
+
+// forward makes the call on the instance owning the company's session,
+// decoding its result into res. It fails with ErrOwnerUnavailable when the
+// owner doesn't answer in time or the lease moved meanwhile.
+func (s *Service) forward(ctx context.Context, method string, req forwardRequest, res any) error {
+	owner, err := s.leaseRepo.Owner(ctx, req.CompanyID)
+	if err != nil {
+		return err
+	}
+	if owner == "" || owner == s.cfg.InstanceID {
+		return fmt.Errorf("%w: lease of %s changed", ErrOwnerUnavailable, req.CompanyID)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	call, cancel := context.WithTimeout(ctx, forwardTimeout)
+	defer cancel()
+	out, err := s.rpc.Call(call, owner, method, body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: %s: %w", ErrOwnerUnavailable, owner, err)
+	}
+	var reply forwardReply
+	if err := json.Unmarshal(out, &reply); err != nil {
+		return fmt.Errorf("decode reply of %s: %w", owner, err)
+	}
+	if reply.Error != nil {
+		err := reply.Error.err()
+		if errors.Is(err, ErrSessionOwnedElsewhere) {
+			return fmt.Errorf("%w: %s gave the session up", ErrOwnerUnavailable, owner)
+		}
+		return err
+	}
+	if res == nil || len(reply.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, res)
+}
+
+// serveForwarded answers a call forwarded by another instance. Calls aren't
+// forwarded again, so when this instance no longer owns the session the
+// caller gets ErrSessionOwnedElsewhere.
+func (s *Service) serveForwarded(ctx context.Context, method string, body []byte) []byte {
+	ctx, cancel := context.WithTimeout(ctx, forwardTimeout)
+	defer cancel()
+	var req forwardRequest
+	var res any
+	err := json.Unmarshal(body, &req)
+	if err == nil {
+		switch method {
+		case methodSend:
+			if req.Message == nil {
+				req.Message = &OutgoingMessage{}
+			}
+			res, err = s.sendLocal(ctx, req.Message)
+		case methodConnect:
+			res, err = s.connectLocal(ctx, req.CompanyID)
+		case methodCheckNumbers:
+			res, err = s.checkNumbersLocal(ctx, req.CompanyID, req.Numbers)
+		case methodSubscribePresence:
+			res, err = s.subscribePresenceLocal(ctx, req.CompanyID, req.To)
+		case methodLogout:
+			err = s.logoutLocal(ctx, req.CompanyID)
+		default:
+			err = fmt.Errorf("unknown method %q", method)
+		}
+	}
+	var reply forwardReply
+	if err != nil {
+		reply.Error = encodeError(err)
+	} else if reply.Result, err = json.Marshal(res); err != nil {
+		reply.Error = encodeError(err)
+	}
+	out, _ := json.Marshal(reply)
+	return out
+}
@@ -0,0 +1,78 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestForwardedErrorsKeepTheirKind(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		check func(error) bool
+	}{
+		{"validation", &ValidationError{Field: "to", Message: "is required"}, func(err error) bool {
+			var v *ValidationError
+			return errors.As(err, &v) && v.Field == "to" && v.Message == "is required"
+		}},
+		{"invalid recipient", &InvalidRecipientError{To: "123", Reason: "not on whatsapp"}, func(err error) bool {
+			var v *InvalidRecipientError
+			return errors.As(err, &v) && v.To == "123" && v.Reason == "not on whatsapp"
+		}},
+		{"rate limited", &RateLimitError{Scope: "company", RetryAfter: 3 * time.Second}, func(err error) bool {
+			var v *RateLimitError
+			return errors.As(err, &v) && v.Scope == "company" && v.RetryAfter == 3*time.Second
+		}},
+		{"banned", fmt.Errorf("%w until 2024-05-01T12:00:00Z", ErrSessionBanned), func(err error) bool {
+			return errors.Is(err, ErrSessionBanned) && err.Error() == "session temporarily banned until 2024-05-01T12:00:00Z"
+		}},
+		{"not paired", ErrNotPaired, func(err error) bool { return errors.Is(err, ErrNotPaired) }},
+		{"other", errors.New("boom"), func(err error) bool {
+			return err.Error() == "boom" && !errors.Is(err, ErrSessionNotFound)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(encodeError(tt.err))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var remote remoteError
+			if err := json.Unmarshal(body, &remote); err != nil {
+				t.Fatal(err)
+			}
+			if got := remote.err(); !tt.check(got) {
+				t.Errorf("forwarded %q came back as %#v", tt.err, got)
+			}
+		})
+	}
+}
+
+func TestServeForwardedReportsErrors(t *testing.T) {
+	s := &Service{cfg: Config{InstanceID: "bot-2"}}
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		want   string
+	}{
+		{"invalid message", methodSend, `{"company_id":"empresa-123","message":{"company_id":"empresa-123","type":"text"}}`, "validation"},
+		{"missing message", methodSend, `{"company_id":"empresa-123"}`, "validation"},
+		{"unknown method", "reboot", `{"company_id":"empresa-123"}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reply forwardReply
+			if err := json.Unmarshal(s.serveForwarded(context.Background(), tt.method, []byte(tt.body)), &reply); err != nil {
+				t.Fatal(err)
+			}
+			if reply.Error == nil || reply.Error.Kind != tt.want || reply.Result != nil {
+				t.Errorf("reply = %+v, want a %q error", reply, tt.want)
+			}
+		})
+	}
+}
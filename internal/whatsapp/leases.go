@@ -0,0 +1,329 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/example/wpp-wave-bot/internal/tracing"
+)
+
+// Every company's session runs on the instance holding its lease, so that
+// only one whatsmeow client connects the device. The owner consumes the
+// company's send queue, to which every instance routes the messages it
+// consumes from wpp:send. Messages to be sent later are scheduled instead.
+
+// sendQueue is the queue the owner of a company's lease sends its messages
+// from.
+func sendQueue(companyID string) string {
+	return "wpp:send:" + companyID
+}
+
+// ownership tracks the companies whose lease this instance holds and runs
+// the consumers of their send queues. It is safe for concurrent use.
+type ownership struct {
+	mu        sync.Mutex
+	consumers map[string]context.CancelFunc
+	// set by start; companies owned earlier are consumed from then on
+	ctx, work context.Context
+	run       func(companyID string, ctx, work context.Context)
+	wg        sync.WaitGroup
+}
+
+func newOwnership() *ownership {
+	return &ownership{consumers: make(map[string]context.CancelFunc)}
+}
+
+// start runs the consumers of the owned companies until ctx is done.
+func (o *ownership) start(ctx, work context.Context, run func(companyID string, ctx, work context.Context)) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.ctx, o.work, o.run = ctx, work, run
+	for id := range o.consumers {
+		o.launch(id)
+	}
+}
+
+// launch starts the consumer of the company. o.mu must be held.
+func (o *ownership) launch(companyID string) {
+	if o.ctx.Err() != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(o.ctx)
+	o.consumers[companyID] = cancel
+	o.wg.Add(1)
+	go func(work context.Context) {
+		defer o.wg.Done()
+		o.run(companyID, ctx, work)
+	}(o.work)
+}
+
+// add records the company as owned, reporting false when it already was.
+func (o *ownership) add(companyID string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.consumers[companyID]; ok {
+		return false
+	}
+	o.consumers[companyID] = nil
+	if o.run != nil {
+		o.launch(companyID)
+	}
+	return true
+}
+
+// remove stops consuming the company, reporting whether it was owned.
+func (o *ownership) remove(companyID string) bool {
+	o.mu.Lock()
+	cancel, ok := o.consumers[companyID]
+	delete(o.consumers, companyID)
+	o.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	return ok
+}
+
+func (o *ownership) has(companyID string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, ok := o.consumers[companyID]
+	return ok
+}
+
+func (o *ownership) ids() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ids := make([]string, 0, len(o.consumers))
+	for id := range o.consumers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// clear forgets every owned company.
+func (o *ownership) clear() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, cancel := range o.consumers {
+		if cancel != nil {
+			cancel()
+		}
+	}
+	o.consumers = make(map[string]context.CancelFunc)
+}
+
+// wait blocks until the consumers stopped.
+func (o *ownership) wait() {
+	o.wg.Wait()
+}
+
+// consumeCompany sends the messages of the company's send queue until ctx
+// is done.
+func (s *Service) consumeCompany(companyID string, ctx, work context.Context) {
+	queue := sendQueue(companyID)
+	err := s.mq.Declare(queue)
+	if err == nil {
+		err = s.consume(ctx, work, queue, s.handleDelivery)
+	}
+	if err != nil {
+		// give the session up so the next message claims it again
+		log.Error().Err(err).Str("company_id", companyID).Msg("send queue consumer stopped")
+		s.disown(companyID, true)
+	}
+}
+
+// route forwards a message consumed from wpp:send to the send queue of its
+// company, claiming the company first when no instance owns it. Payloads
+// without a company are handled right away so their failure is reported,
+// and ones with a future send_at are scheduled.
+func (s *Service) route(ctx context.Context, d amqp.Delivery) bool {
+	if handled, ok := s.scheduleDelivery(ctx, d); handled {
+		return ok
+	}
+	var m struct {
+		CompanyID string `json:"company_id"`
+	}
+	if err := json.Unmarshal(d.Body, &m); err != nil || m.CompanyID == "" {
+		return s.handleDelivery(ctx, d)
+	}
+	ctx, span := tracing.Start(tracing.Extract(ctx, d.Headers), "route wpp:send",
+		trace.WithSpanKind(trace.SpanKindConsumer), tracing.QueueAttrs("wpp:send"))
+	defer span.End()
+	logger := log.With().Str("company_id", m.CompanyID).Logger()
+
+	owner, err := s.leaseRepo.Owner(ctx, m.CompanyID)
+	if err == nil && owner == "" {
+		err = s.claim(ctx, m.CompanyID)
+	}
+	if err != nil && !errors.Is(err, ErrSessionOwnedElsewhere) {
+		logger.Error().Err(err).Msg("failed to find session owner")
+		span.SetStatus(codes.Error, err.Error())
+		return false
+	}
+
+	queue := sendQueue(m.CompanyID)
+	err = s.mq.Declare(queue)
+	if err == nil {
+		err = s.mq.Forward(ctx, queue, d)
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to route message")
+		span.SetStatus(codes.Error, err.Error())
+		return false
+	}
+	return true
+}
+
+// claim makes this instance the owner of the company's session, failing
+// with ErrSessionOwnedElsewhere while another instance holds its lease.
+func (s *Service) claim(ctx context.Context, companyID string) error {
+	if s.owned.has(companyID) {
+		return nil
+	}
+	ok, err := s.leaseRepo.Acquire(ctx, companyID, s.cfg.InstanceID, s.cfg.LeaseTTL)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := s.checkOwner(ctx, companyID); err != nil {
+			return err
+		}
+		return ErrSessionOwnedElsewhere
+	}
+	if s.owned.add(companyID) {
+		log.Info().Str("company_id", companyID).Msg("session lease acquired")
+	}
+	return nil
+}
+
+// checkOwner fails with ErrSessionOwnedElsewhere when another instance holds
+// the lease of the company's session.
+func (s *Service) checkOwner(ctx context.Context, companyID string) error {
+	owner, err := s.leaseRepo.Owner(ctx, companyID)
+	if err != nil {
+		return err
+	}
+	if owner != "" && owner != s.cfg.InstanceID {
+		return fmt.Errorf("%w: %s", ErrSessionOwnedElsewhere, owner)
+	}
+	return nil
+}
+
+// disown stops running the company's session on this instance: its send
+// queue is no longer consumed and its client is unloaded. With release the
+// lease is given up as well.
+func (s *Service) disown(companyID string, release bool) {
+	if !s.owned.remove(companyID) {
+		return
+	}
+	if cli, ok := s.clients.client(companyID); ok {
+		s.unload(companyID, cli)
+	}
+	if !release {
+		return
+	}
+	if err := s.leaseRepo.Release(context.Background(), companyID, s.cfg.InstanceID); err != nil {
+		log.Error().Err(err).Str("company_id", companyID).Msg("failed to release session lease")
+	}
+}
+
+// keepLeases renews the leases of this instance and claims the sessions no
+// live instance owns, every third of Config.LeaseTTL until ctx is done.
+// Leases that couldn't be renewed for a whole TTL may belong to other
+// instances already, so their sessions are given up.
+func (s *Service) keepLeases(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.LeaseTTL / 3)
+	defer ticker.Stop()
+	renewed := time.Now()
+	for {
+		if err := s.renewLeases(ctx); err == nil {
+			renewed = time.Now()
+			s.claimOrphans(ctx)
+		} else if ctx.Err() == nil {
+			log.Error().Err(err).Msg("failed to renew session leases")
+			if time.Since(renewed) >= s.cfg.LeaseTTL {
+				log.Warn().Msg("session leases expired, giving up all sessions")
+				for _, id := range s.owned.ids() {
+					s.disown(id, false)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// renewLeases extends the leases of the owned companies, giving up the
+// sessions whose lease was taken over.
+func (s *Service) renewLeases(ctx context.Context) error {
+	ids := s.owned.ids()
+	if len(ids) == 0 {
+		return nil
+	}
+	kept, err := s.leaseRepo.Renew(ctx, s.cfg.InstanceID, ids, s.cfg.LeaseTTL)
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]bool, len(kept))
+	for _, id := range kept {
+		keep[id] = true
+	}
+	for _, id := range ids {
+		if !keep[id] {
+			log.Warn().Str("company_id", id).Msg("session lease lost")
+			s.disown(id, false)
+		}
+	}
+	return nil
+}
+
+// claimOrphans claims the sessions without a live owner and connects the
+// paired ones, which fails sessions over from instances that died.
+func (s *Service) claimOrphans(ctx context.Context) {
+	ids, err := s.leaseRepo.Orphaned(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list orphaned sessions")
+		return
+	}
+	for _, id := range ids {
+		if s.owned.has(id) {
+			continue
+		}
+		if err := s.claim(ctx, id); err != nil {
+			if !errors.Is(err, ErrSessionOwnedElsewhere) {
+				log.Error().Err(err).Str("company_id", id).Msg("failed to claim session")
+			}
+			continue
+		}
+		go s.restore(ctx, id)
+	}
+}
+
+// restore connects the paired session of a company this instance claimed.
+func (s *Service) restore(ctx context.Context, companyID string) {
+	sess, err := s.sessionRepo.Get(ctx, companyID)
+	if err != nil {
+		log.Error().Err(err).Str("company_id", companyID).Msg("failed to load session")
+		return
+	}
+	if sess == nil {
+		return
+	}
+	if _, _, err := s.getClient(ctx, companyID); err != nil && !errors.Is(err, ErrSessionBanned) {
+		log.Warn().Err(err).Str("company_id", companyID).Msg("failed to restore session")
+	}
+}
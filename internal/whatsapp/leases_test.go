@@ -0,0 +1,337 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/example/wpp-wave-bot/internal/sessions"
+)
+
+// fakeLeases keeps the leases in memory, with no expiry unless set.
+type fakeLeases struct {
+	mu       sync.Mutex
+	owners   map[string]string
+	orphaned []string
+	// err fails every call when set
+	err      error
+	acquired []string
+}
+
+func (f *fakeLeases) Acquire(_ context.Context, companyID, owner string, _ time.Duration) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.acquired = append(f.acquired, companyID)
+	if f.err != nil {
+		return false, f.err
+	}
+	if cur, ok := f.owners[companyID]; ok && cur != owner {
+		return false, nil
+	}
+	f.owners[companyID] = owner
+	return true, nil
+}
+
+func (f *fakeLeases) Renew(_ context.Context, owner string, companyIDs []string, _ time.Duration) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	var kept []string
+	for _, id := range companyIDs {
+		if f.owners[id] == owner {
+			kept = append(kept, id)
+		}
+	}
+	return kept, nil
+}
+
+func (f *fakeLeases) Release(_ context.Context, companyID, owner string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.owners[companyID] == owner {
+		delete(f.owners, companyID)
+	}
+	return nil
+}
+
+func (f *fakeLeases) ReleaseAll(_ context.Context, owner string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for id, o := range f.owners {
+		if o == owner {
+			delete(f.owners, id)
+		}
+	}
+	return nil
+}
+
+func (f *fakeLeases) Owner(_ context.Context, companyID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.owners[companyID], f.err
+}
+
+func (f *fakeLeases) Owners(context.Context) (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.owners, f.err
+}
+
+func (f *fakeLeases) Orphaned(context.Context) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.orphaned, f.err
+}
+
+func (f *fakeLeases) set(companyID, owner string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.owners[companyID] = owner
+}
+
+// fakeBroker records the messages forwarded to queues.
+type fakeBroker struct {
+	mu        sync.Mutex
+	forwarded map[string][]string
+	err       error
+}
+
+func (f *fakeBroker) Declare(string) error { return f.err }
+func (f *fakeBroker) Consume(string) (string, <-chan amqp.Delivery, error) {
+	return "", nil, errors.New("not consuming")
+}
+func (f *fakeBroker) Subscribe(string, string) (string, <-chan amqp.Delivery, error) {
+	return "", nil, errors.New("not subscribing")
+}
+func (f *fakeBroker) Cancel(string) error                                     { return nil }
+func (f *fakeBroker) Publish(context.Context, string, string, []byte) error   { return nil }
+func (f *fakeBroker) Broadcast(context.Context, string, string, []byte) error { return nil }
+func (f *fakeBroker) Forward(_ context.Context, queue string, d amqp.Delivery) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.forwarded[queue] = append(f.forwarded[queue], string(d.Body))
+	return nil
+}
+
+// fakeSessions has no sessions and records the ones looked up.
+type fakeSessions struct {
+	mu  sync.Mutex
+	got []string
+}
+
+func (f *fakeSessions) Get(_ context.Context, companyID string) (*sessions.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.got = append(f.got, companyID)
+	return nil, nil
+}
+
+func (f *fakeSessions) lookedUp() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.got)
+}
+
+func (f *fakeSessions) List(context.Context) ([]sessions.Session, error)            { return nil, nil }
+func (f *fakeSessions) SavePaired(context.Context, string, string, string) error    { return nil }
+func (f *fakeSessions) MarkConnected(context.Context, string, string, string) error { return nil }
+func (f *fakeSessions) MarkDisconnected(context.Context, string) error              { return nil }
+func (f *fakeSessions) SetError(context.Context, string, string) error              { return nil }
+func (f *fakeSessions) SetBanned(context.Context, string, time.Time, string) error  { return nil }
+func (f *fakeSessions) Delete(context.Context, string) error                        { return nil }
+
+type leaseFixture struct {
+	s        *Service
+	leases   *fakeLeases
+	mq       *fakeBroker
+	sessions *fakeSessions
+}
+
+func newLeaseFixture(ttl time.Duration) *leaseFixture {
+	f := &leaseFixture{
+		leases:   &fakeLeases{owners: make(map[string]string)},
+		mq:       &fakeBroker{forwarded: make(map[string][]string)},
+		sessions: &fakeSessions{},
+	}
+	f.s = &Service{
+		cfg:         Config{InstanceID: "bot-1", LeaseTTL: ttl},
+		mq:          f.mq,
+		clients:     newRegistry(),
+		owned:       newOwnership(),
+		leaseRepo:   f.leases,
+		sessionRepo: f.sessions,
+	}
+	return f
+}
+
+// eventually polls cond for a second.
+func eventually(t *testing.T, cond func() bool) bool {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestClaim(t *testing.T) {
+	tests := []struct {
+		name     string
+		owner    string
+		owned    bool
+		err      error
+		wantErr  error
+		wantOwn  bool
+		acquires int
+	}{
+		{name: "free", wantOwn: true, acquires: 1},
+		{name: "owned by us", owner: "bot-1", wantOwn: true, acquires: 1},
+		{name: "owned elsewhere", owner: "bot-2", wantErr: ErrSessionOwnedElsewhere, acquires: 1},
+		{name: "already claimed", owned: true, wantOwn: true},
+		{name: "database down", err: errors.New("connection refused"), wantErr: errors.New("connection refused"), acquires: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newLeaseFixture(time.Minute)
+			if tt.owner != "" {
+				f.leases.set("empresa-123", tt.owner)
+			}
+			if tt.owned {
+				f.s.owned.add("empresa-123")
+			}
+			f.leases.err = tt.err
+
+			err := f.s.claim(context.Background(), "empresa-123")
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("claim: %v", err)
+			case tt.wantErr != nil && (err == nil || !errors.Is(err, tt.wantErr) && err.Error() != tt.wantErr.Error()):
+				t.Fatalf("claim = %v, want %v", err, tt.wantErr)
+			}
+			if got := f.s.owned.has("empresa-123"); got != tt.wantOwn {
+				t.Errorf("owned = %v, want %v", got, tt.wantOwn)
+			}
+			if len(f.leases.acquired) != tt.acquires {
+				t.Errorf("%d acquires, want %d", len(f.leases.acquired), tt.acquires)
+			}
+		})
+	}
+}
+
+func TestRoute(t *testing.T) {
+	const body = `{"company_id":"empresa-123","type":"text","to":"5511999999999","message":"oi"}`
+	tests := []struct {
+		name       string
+		owner      string
+		leaseErr   error
+		forwardErr error
+		wantAck    bool
+		wantRouted bool
+		wantOwned  bool
+	}{
+		{name: "owned elsewhere", owner: "bot-2", wantAck: true, wantRouted: true},
+		{name: "owned by us", owner: "bot-1", wantAck: true, wantRouted: true},
+		{name: "no owner", wantAck: true, wantRouted: true, wantOwned: true},
+		{name: "owner unknown", leaseErr: errors.New("connection refused")},
+		{name: "broker down", owner: "bot-2", forwardErr: errors.New("channel closed")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newLeaseFixture(time.Minute)
+			if tt.owner != "" {
+				f.leases.set("empresa-123", tt.owner)
+			}
+			f.leases.err = tt.leaseErr
+			f.mq.err = tt.forwardErr
+
+			ack := f.s.route(context.Background(), amqp.Delivery{Body: []byte(body)})
+			if ack != tt.wantAck {
+				t.Errorf("route acked = %v, want %v", ack, tt.wantAck)
+			}
+			routed := f.mq.forwarded["wpp:send:empresa-123"]
+			if got := len(routed) == 1 && routed[0] == body; got != tt.wantRouted {
+				t.Errorf("routed to the company queue: %v, want %v (%v)", got, tt.wantRouted, f.mq.forwarded)
+			}
+			if got := f.s.owned.has("empresa-123"); got != tt.wantOwned {
+				t.Errorf("owned = %v, want %v", got, tt.wantOwned)
+			}
+		})
+	}
+}
+
+func TestRenewLeasesGivesUpLostSessions(t *testing.T) {
+	f := newLeaseFixture(time.Minute)
+	for _, id := range []string{"empresa-1", "empresa-2"} {
+		if err := f.s.claim(context.Background(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// empresa-2's lease expired and another instance took it
+	f.leases.set("empresa-2", "bot-2")
+
+	if err := f.s.renewLeases(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ids := f.s.owned.ids(); !slices.Equal(ids, []string{"empresa-1"}) {
+		t.Errorf("owned = %v, want only empresa-1", ids)
+	}
+	if owner, _ := f.leases.Owner(context.Background(), "empresa-2"); owner != "bot-2" {
+		t.Errorf("lease of empresa-2 held by %q, want it left to bot-2", owner)
+	}
+}
+
+func TestKeepLeasesGivesUpSessionsAfterTTL(t *testing.T) {
+	f := newLeaseFixture(30 * time.Millisecond)
+	if err := f.s.claim(context.Background(), "empresa-123"); err != nil {
+		t.Fatal(err)
+	}
+	f.leases.mu.Lock()
+	f.leases.err = errors.New("connection refused")
+	f.leases.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.s.keepLeases(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	if !eventually(t, func() bool { return len(f.s.owned.ids()) == 0 }) {
+		t.Fatal("sessions kept although their leases couldn't be renewed for a whole TTL")
+	}
+}
+
+func TestClaimOrphans(t *testing.T) {
+	f := newLeaseFixture(time.Minute)
+	f.s.owned.add("empresa-1")
+	f.leases.set("empresa-1", "bot-1")
+	// empresa-3 was taken by another instance since it was listed
+	f.leases.set("empresa-3", "bot-2")
+	f.leases.orphaned = []string{"empresa-1", "empresa-2", "empresa-3"}
+
+	f.s.claimOrphans(context.Background())
+	if !eventually(t, func() bool { return slices.Equal(f.sessions.lookedUp(), []string{"empresa-2"}) }) {
+		t.Fatalf("restored %v, want empresa-2", f.sessions.lookedUp())
+	}
+	if ids := f.s.owned.ids(); !slices.Equal(ids, []string{"empresa-1", "empresa-2"}) {
+		t.Errorf("owned = %v, want empresa-1 and empresa-2", ids)
+	}
+	if owner, _ := f.leases.Owner(context.Background(), "empresa-3"); owner != "bot-2" {
+		t.Errorf("lease of empresa-3 held by %q, want bot-2", owner)
+	}
+}
@@ -0,0 +1,217 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/example/wpp-wave-bot/internal/metrics"
+)
+
+// Limit is a token bucket rate. A zero PerMinute disables it.
+type Limit struct {
+	// PerMinute is the sustained number of messages per minute.
+	PerMinute float64
+	// Burst is how many messages may go out back to back, at least 1.
+	Burst int
+}
+
+// Limits throttle outgoing messages so numbers don't get banned for
+// blasting. A send waits until the global, company and recipient limits all
+// allow it.
+type Limits struct {
+	Global    Limit
+	Company   Limit
+	Recipient Limit
+	// MaxWait is how long sends through the API may wait for the limits
+	// before failing with a RateLimitError. Queued messages wait as long as
+	// needed, holding back the rest of the company's queue.
+	MaxWait time.Duration
+	// JitterMin and JitterMax bound a random delay added before every send
+	// to pace messages like a person would.
+	JitterMin time.Duration
+	JitterMax time.Duration
+}
+
+// RateLimitError is returned when a send would wait for the rate limits
+// longer than Limits.MaxWait.
+type RateLimitError struct {
+	// Scope is the limit that held the send: global, company or recipient.
+	Scope string
+	// RetryAfter is how long until the send would be allowed.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s rate limit exceeded, retry after %s", e.Scope, e.RetryAfter.Round(time.Second))
+}
+
+// RateLimitStatus is the state of a company's rate limit.
+type RateLimitStatus struct {
+	// Tokens is how many messages may go out right now.
+	Tokens float64 `json:"tokens"`
+	// Waiting is how many sends are waiting for the limits.
+	Waiting int `json:"waiting"`
+}
+
+// sweepInterval is how often buckets that refilled are forgotten.
+const sweepInterval = time.Minute
+
+// bucket is the limiter of a company or recipient.
+type bucket struct {
+	lim     *rate.Limiter
+	waiting int
+}
+
+// limiter applies Limits. It is safe for concurrent use.
+type limiter struct {
+	cfg    Limits
+	global *rate.Limiter
+
+	mu         sync.Mutex
+	companies  map[string]*bucket
+	recipients map[string]*bucket
+	swept      time.Time
+}
+
+func newLimiter(cfg Limits) *limiter {
+	l := &limiter{
+		cfg:        cfg,
+		companies:  make(map[string]*bucket),
+		recipients: make(map[string]*bucket),
+		swept:      time.Now(),
+	}
+	if cfg.Global.PerMinute > 0 {
+		l.global = newRate(cfg.Global)
+	}
+	return l
+}
+
+func newRate(lim Limit) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(lim.PerMinute/60), max(lim.Burst, 1))
+}
+
+// get returns the bucket of key in m, creating it for lim. l.mu must be
+// held.
+func (l *limiter) get(m map[string]*bucket, key string, lim Limit) *bucket {
+	b, ok := m[key]
+	if !ok {
+		b = &bucket{lim: newRate(lim)}
+		m[key] = b
+	}
+	return b
+}
+
+// sweep forgets the idle buckets that refilled, which behave like new
+// ones. l.mu must be held.
+func (l *limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+	for _, m := range []map[string]*bucket{l.companies, l.recipients} {
+		for key, b := range m {
+			if b.waiting == 0 && b.lim.TokensAt(now) >= float64(b.lim.Burst()) {
+				delete(m, key)
+			}
+		}
+	}
+}
+
+// wait blocks until the limits allow a message from the company to the
+// recipient, then for the configured jitter. With maxWait set it fails with
+// a RateLimitError instead when the limits would hold the message longer.
+func (l *limiter) wait(ctx context.Context, companyID, recipient string, maxWait time.Duration) error {
+	now := time.Now()
+	type hold struct {
+		scope string
+		res   *rate.Reservation
+	}
+	var holds []hold
+	var waiting []*bucket
+
+	l.mu.Lock()
+	l.sweep(now)
+	if l.global != nil {
+		holds = append(holds, hold{"global", l.global.ReserveN(now, 1)})
+	}
+	if l.cfg.Company.PerMinute > 0 {
+		b := l.get(l.companies, companyID, l.cfg.Company)
+		holds = append(holds, hold{"company", b.lim.ReserveN(now, 1)})
+		waiting = append(waiting, b)
+	}
+	if l.cfg.Recipient.PerMinute > 0 {
+		b := l.get(l.recipients, companyID+"|"+recipient, l.cfg.Recipient)
+		holds = append(holds, hold{"recipient", b.lim.ReserveN(now, 1)})
+		waiting = append(waiting, b)
+	}
+	var delay time.Duration
+	scope := ""
+	for _, h := range holds {
+		if d := h.res.DelayFrom(now); d > delay {
+			delay, scope = d, h.scope
+		}
+	}
+	if maxWait > 0 && delay > maxWait {
+		for _, h := range holds {
+			h.res.CancelAt(now)
+		}
+		l.mu.Unlock()
+		metrics.RateLimitRejected.WithLabelValues(companyID, scope).Inc()
+		return &RateLimitError{Scope: scope, RetryAfter: delay}
+	}
+	for _, b := range waiting {
+		b.waiting++
+	}
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		for _, b := range waiting {
+			b.waiting--
+		}
+		l.mu.Unlock()
+	}()
+	if delay > 0 {
+		metrics.RateLimitWaiting.WithLabelValues(companyID).Inc()
+		defer metrics.RateLimitWaiting.WithLabelValues(companyID).Dec()
+		metrics.RateLimitWait.WithLabelValues(scope).Observe(delay.Seconds())
+	}
+
+	if l.cfg.JitterMax > 0 {
+		delay += l.cfg.JitterMin + rand.N(l.cfg.JitterMax-l.cfg.JitterMin+1)
+	}
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// give back the tokens the message didn't use
+		for _, h := range holds {
+			h.res.Cancel()
+		}
+		return ctx.Err()
+	}
+}
+
+// status reports the company's limit, or nil when it has none.
+func (l *limiter) status(companyID string) *RateLimitStatus {
+	if l.cfg.Company.PerMinute <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.companies[companyID]
+	if !ok {
+		return &RateLimitStatus{Tokens: float64(max(l.cfg.Company.Burst, 1))}
+	}
+	return &RateLimitStatus{Tokens: max(b.lim.Tokens(), 0), Waiting: b.waiting}
+}
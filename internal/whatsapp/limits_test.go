@@ -0,0 +1,177 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterRejects(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        Limits
+		maxWait    time.Duration
+		sent       []string
+		to         string
+		scope      string
+		retryAfter time.Duration
+	}{
+		{
+			name:       "recipient",
+			cfg:        Limits{Company: Limit{PerMinute: 60, Burst: 5}, Recipient: Limit{PerMinute: 1, Burst: 1}},
+			maxWait:    time.Second,
+			sent:       []string{"5511999999999"},
+			to:         "5511999999999",
+			scope:      "recipient",
+			retryAfter: time.Minute,
+		},
+		{
+			name:       "company",
+			cfg:        Limits{Company: Limit{PerMinute: 6, Burst: 1}, Recipient: Limit{PerMinute: 60, Burst: 1}},
+			maxWait:    time.Second,
+			sent:       []string{"5511999999999"},
+			to:         "5511888888888",
+			scope:      "company",
+			retryAfter: 10 * time.Second,
+		},
+		{
+			name:       "global",
+			cfg:        Limits{Global: Limit{PerMinute: 2, Burst: 1}, Company: Limit{PerMinute: 6, Burst: 1}},
+			maxWait:    time.Second,
+			sent:       []string{"5511999999999"},
+			to:         "5511888888888",
+			scope:      "global",
+			retryAfter: 30 * time.Second,
+		},
+		{
+			name:       "longest hold wins",
+			cfg:        Limits{Company: Limit{PerMinute: 6, Burst: 1}, Recipient: Limit{PerMinute: 1, Burst: 1}},
+			maxWait:    time.Second,
+			sent:       []string{"5511999999999"},
+			to:         "5511999999999",
+			scope:      "recipient",
+			retryAfter: time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLimiter(tt.cfg)
+			ctx := context.Background()
+			for _, to := range tt.sent {
+				if err := l.wait(ctx, "empresa-123", to, tt.maxWait); err != nil {
+					t.Fatalf("wait(%s) = %v", to, err)
+				}
+			}
+
+			err := l.wait(ctx, "empresa-123", tt.to, tt.maxWait)
+			var limited *RateLimitError
+			if !errors.As(err, &limited) {
+				t.Fatalf("wait = %v, want RateLimitError", err)
+			}
+			if limited.Scope != tt.scope {
+				t.Errorf("Scope = %s, want %s", limited.Scope, tt.scope)
+			}
+			if limited.RetryAfter > tt.retryAfter || limited.RetryAfter < tt.retryAfter-time.Second {
+				t.Errorf("RetryAfter = %s, want about %s", limited.RetryAfter, tt.retryAfter)
+			}
+
+			// other companies have their own buckets
+			if tt.scope != "global" {
+				if err := l.wait(ctx, "empresa-456", tt.to, tt.maxWait); err != nil {
+					t.Errorf("wait for another company = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterRejectionKeepsTokens(t *testing.T) {
+	l := newLimiter(Limits{Company: Limit{PerMinute: 1, Burst: 3}, Recipient: Limit{PerMinute: 1, Burst: 1}})
+	ctx := context.Background()
+	if err := l.wait(ctx, "empresa-123", "5511999999999", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	before := l.status("empresa-123").Tokens
+
+	var limited *RateLimitError
+	if err := l.wait(ctx, "empresa-123", "5511999999999", time.Second); !errors.As(err, &limited) {
+		t.Fatalf("wait = %v, want RateLimitError", err)
+	}
+	// the company token reserved alongside the recipient one is given back
+	if after := l.status("empresa-123").Tokens; after < before {
+		t.Errorf("tokens = %.2f after the rejection, want %.2f", after, before)
+	}
+}
+
+func TestLimiterCancelReturnsTokens(t *testing.T) {
+	l := newLimiter(Limits{Company: Limit{PerMinute: 60, Burst: 1}})
+	if err := l.wait(context.Background(), "empresa-123", "5511999999999", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- l.wait(ctx, "empresa-123", "5511999999999", 0) }()
+
+	deadline := time.Now().Add(time.Second)
+	for l.status("empresa-123").Waiting != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("send isn't waiting for the limit")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("wait = %v, want context.Canceled", err)
+	}
+
+	l.mu.Lock()
+	tokens := l.companies["empresa-123"].lim.Tokens()
+	l.mu.Unlock()
+	// without giving back its token the bucket would be about one in debt
+	if tokens < -0.5 {
+		t.Errorf("tokens = %.2f after the cancelled send, want about 0", tokens)
+	}
+	if st := l.status("empresa-123"); st.Waiting != 0 {
+		t.Errorf("Waiting = %d after the cancelled send", st.Waiting)
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	l := newLimiter(Limits{Company: Limit{PerMinute: 60, Burst: 1}, Recipient: Limit{PerMinute: 0.5, Burst: 1}})
+	ctx := context.Background()
+	for _, company := range []string{"empresa-123", "empresa-456"} {
+		if err := l.wait(ctx, company, "5511999999999", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.companies["empresa-456"].waiting = 1
+
+	now := time.Now()
+	l.sweep(now)
+	if len(l.companies) != 2 || len(l.recipients) != 2 {
+		t.Fatalf("swept before sweepInterval: %d companies, %d recipients", len(l.companies), len(l.recipients))
+	}
+
+	later := l.swept.Add(sweepInterval + time.Second)
+	l.sweep(later)
+	if _, ok := l.companies["empresa-123"]; ok {
+		t.Error("refilled company bucket kept")
+	}
+	if _, ok := l.companies["empresa-456"]; !ok {
+		t.Error("company bucket with a waiting send forgotten")
+	}
+	// the recipient limit takes two minutes to refill
+	if len(l.recipients) != 2 {
+		t.Errorf("recipient buckets = %d, want 2 until they refill", len(l.recipients))
+	}
+
+	l.sweep(later.Add(2 * sweepInterval))
+	if len(l.recipients) != 0 {
+		t.Errorf("recipient buckets = %d, want 0 once refilled", len(l.recipients))
+	}
+}
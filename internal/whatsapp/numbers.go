@@ -0,0 +1,114 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"go.mau.fi/whatsmeow"
+
+	"github.com/example/wpp-wave-bot/internal/contacts"
+)
+
+// NumberCheck is the registration status of a phone number on WhatsApp.
+type NumberCheck struct {
+	Input      string `json:"input"`
+	Phone      string `json:"phone,omitempty"`
+	JID        string `json:"jid,omitempty"`
+	Registered bool   `json:"registered"`
+	Error      string `json:"error,omitempty"`
+}
+
+// CheckNumbers reports which of the given phone numbers are registered on
+// WhatsApp. Lookups are cached so repeated checks don't hit WhatsApp.
+func (s *Service) CheckNumbers(ctx context.Context, companyID string, numbers []string) ([]NumberCheck, error) {
+	results, err := s.checkNumbersLocal(ctx, companyID, numbers)
+	if errors.Is(err, ErrSessionOwnedElsewhere) {
+		results = nil
+		err = s.forward(ctx, methodCheckNumbers, forwardRequest{CompanyID: companyID, Numbers: numbers}, &results)
+	}
+	return results, err
+}
+
+func (s *Service) checkNumbersLocal(ctx context.Context, companyID string, numbers []string) ([]NumberCheck, error) {
+	cli, _, err := s.getClient(ctx, companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]NumberCheck, len(numbers))
+	phones := make([]string, 0, len(numbers))
+	for i, n := range numbers {
+		results[i].Input = n
+		phone, err := contacts.NormalizePhone(n, s.cfg.DefaultCountry)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Phone = phone
+		phones = append(phones, phone)
+	}
+
+	checks, err := s.lookupNumbers(ctx, cli, companyID, phones)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		if c, ok := checks[results[i].Phone]; ok {
+			results[i].JID = c.JID
+			results[i].Registered = c.Registered
+		}
+	}
+	return results, nil
+}
+
+// lookupNumbers resolves normalized phones using the cache first and
+// IsOnWhatsApp for the rest.
+func (s *Service) lookupNumbers(ctx context.Context, cli *whatsmeow.Client, companyID string, phones []string) (map[string]contacts.Check, error) {
+	if len(phones) == 0 {
+		return map[string]contacts.Check{}, nil
+	}
+	checks, err := s.contactRepo.Checks(ctx, companyID, phones, s.cfg.NumberCheckTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing, query []string
+	for _, p := range phones {
+		if _, ok := checks[p]; !ok {
+			missing = append(missing, p)
+			query = append(query, "+"+p)
+		}
+	}
+	if len(missing) == 0 {
+		return checks, nil
+	}
+
+	resp, err := cli.IsOnWhatsApp(query)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	for _, r := range resp {
+		c := contacts.Check{Phone: strings.TrimPrefix(r.Query, "+"), Registered: r.IsIn, CheckedAt: now}
+		if r.IsIn {
+			c.JID = r.JID.String()
+		}
+		checks[c.Phone] = c
+	}
+	for _, p := range missing {
+		// numbers WhatsApp didn't answer for are treated as unregistered
+		c, ok := checks[p]
+		if !ok {
+			c = contacts.Check{Phone: p, CheckedAt: now}
+			checks[p] = c
+		}
+		if err := s.contactRepo.SaveCheck(ctx, companyID, c); err != nil {
+			log.Error().Err(err).Str("company_id", companyID).Msg("failed to cache number check")
+		}
+	}
+	return checks, nil
+}
@@ -0,0 +1,92 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+	waEvents "go.mau.fi/whatsmeow/types/events"
+)
+
+// PresenceEvent reports that a contact came online or went offline, or
+// started or stopped typing in a chat. Presence is only streamed to the
+// admin API event streams, never to the queues or webhooks.
+type PresenceEvent struct {
+	Event     string `json:"event"`
+	CompanyID string `json:"company_id"`
+	JID       string `json:"jid"`
+	// Chat is set for typing updates.
+	Chat string `json:"chat,omitempty"`
+	// State is available, unavailable, composing, recording or paused.
+	State    string     `json:"state"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+// SubscribePresence asks WhatsApp for the online status of a contact, which
+// is not sent otherwise.
+func (s *Service) SubscribePresence(ctx context.Context, companyID, to string) (string, error) {
+	jid, err := s.subscribePresenceLocal(ctx, companyID, to)
+	if errors.Is(err, ErrSessionOwnedElsewhere) {
+		err = s.forward(ctx, methodSubscribePresence, forwardRequest{CompanyID: companyID, To: to}, &jid)
+	}
+	return jid, err
+}
+
+func (s *Service) subscribePresenceLocal(ctx context.Context, companyID, to string) (string, error) {
+	cli, _, err := s.getClient(ctx, companyID)
+	if err != nil {
+		return "", err
+	}
+	if cli.Store.ID == nil {
+		return "", ErrNotPaired
+	}
+	jid, err := s.resolveRecipient(ctx, cli, companyID, to)
+	if err != nil {
+		return "", err
+	}
+	// WhatsApp only delivers presence to clients that are available themselves.
+	if err := cli.SendPresence(waTypes.PresenceAvailable); err != nil {
+		return "", err
+	}
+	if err := cli.SubscribePresence(jid); err != nil {
+		return "", err
+	}
+	return jid.String(), nil
+}
+
+func (s *Service) handlePresence(companyID string, evt *waEvents.Presence) {
+	out := PresenceEvent{
+		CompanyID: companyID,
+		JID:       evt.From.String(),
+		State:     "available",
+	}
+	if evt.Unavailable {
+		out.State = "unavailable"
+		if !evt.LastSeen.IsZero() {
+			seen := evt.LastSeen.UTC()
+			out.LastSeen = &seen
+		}
+	}
+	s.publishPresence(out)
+}
+
+func (s *Service) handleChatPresence(companyID string, evt *waEvents.ChatPresence) {
+	state := string(evt.State)
+	if evt.State == waTypes.ChatPresenceComposing && evt.Media == waTypes.ChatPresenceMediaAudio {
+		state = "recording"
+	}
+	s.publishPresence(PresenceEvent{
+		CompanyID: companyID,
+		JID:       evt.Sender.String(),
+		Chat:      evt.Chat.String(),
+		State:     state,
+	})
+}
+
+func (s *Service) publishPresence(evt PresenceEvent) {
+	evt.Event = "presence"
+	body, _ := json.Marshal(evt)
+	s.stream(evt.CompanyID, "presence", body)
+}
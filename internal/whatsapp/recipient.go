@@ -0,0 +1,107 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	waTypes "go.mau.fi/whatsmeow/types"
+
+	"github.com/example/wpp-wave-bot/internal/contacts"
+)
+
+// InvalidRecipientError is returned when the recipient of an outgoing message
+// can't be turned into a valid WhatsApp JID.
+type InvalidRecipientError struct {
+	To     string
+	Reason string
+}
+
+func (e *InvalidRecipientError) Error() string {
+	return fmt.Sprintf("invalid recipient %q: %s", e.To, e.Reason)
+}
+
+// resolveRecipient normalizes the many ways a recipient can be addressed into
+// the JID whatsmeow expects. Accepted forms are:
+//
+//   - phone numbers, either E.164 or local to the default country
+//   - user JIDs on @c.us (WhatsApp Web.js style) or @s.whatsapp.net
+//   - group JIDs on @g.us
+//   - hidden user JIDs on @lid
+//
+// Phone numbers and user JIDs are checked against WhatsApp so messages to
+// unregistered numbers fail before any media is downloaded.
+func (s *Service) resolveRecipient(ctx context.Context, cli *whatsmeow.Client, companyID, to string) (waTypes.JID, error) {
+	jid, phone, err := parseRecipient(to, s.cfg.DefaultCountry)
+	if err != nil || phone == "" {
+		return jid, err
+	}
+	checks, err := s.lookupNumbers(ctx, cli, companyID, []string{phone})
+	if err != nil {
+		return waTypes.EmptyJID, err
+	}
+	c := checks[phone]
+	if !c.Registered {
+		return waTypes.EmptyJID, &InvalidRecipientError{To: strings.TrimSpace(to), Reason: "not on whatsapp"}
+	}
+	return waTypes.ParseJID(c.JID)
+}
+
+// parseRecipient validates a recipient without asking WhatsApp. Groups and
+// hidden users are returned as a JID, while phone numbers and user JIDs are
+// returned as the normalized phone to look up.
+func parseRecipient(to, defaultCountry string) (jid waTypes.JID, phone string, err error) {
+	to = strings.TrimSpace(to)
+	if to == "" {
+		return waTypes.EmptyJID, "", &InvalidRecipientError{To: to, Reason: "empty recipient"}
+	}
+
+	user, server, isJID := strings.Cut(to, "@")
+	if !isJID {
+		return parsePhone(to, to, defaultCountry)
+	}
+	// drop device suffixes such as "5511999999999:12"
+	user, _, _ = strings.Cut(user, ":")
+
+	switch server {
+	case waTypes.LegacyUserServer, waTypes.DefaultUserServer:
+		if !isDigits(user) {
+			return waTypes.EmptyJID, "", &InvalidRecipientError{To: to, Reason: "user part must be a phone number"}
+		}
+		return parsePhone(to, "+"+user, defaultCountry)
+	case waTypes.GroupServer:
+		creator, created, legacy := strings.Cut(user, "-")
+		if !isDigits(creator) || (legacy && !isDigits(created)) {
+			return waTypes.EmptyJID, "", &InvalidRecipientError{To: to, Reason: "malformed group id"}
+		}
+		return waTypes.NewJID(user, waTypes.GroupServer), "", nil
+	case waTypes.HiddenUserServer:
+		if !isDigits(user) {
+			return waTypes.EmptyJID, "", &InvalidRecipientError{To: to, Reason: "malformed lid"}
+		}
+		return waTypes.NewJID(user, waTypes.HiddenUserServer), "", nil
+	default:
+		return waTypes.EmptyJID, "", &InvalidRecipientError{To: to, Reason: fmt.Sprintf("unsupported server %q", server)}
+	}
+}
+
+func parsePhone(to, raw, defaultCountry string) (waTypes.JID, string, error) {
+	phone, err := contacts.NormalizePhone(raw, defaultCountry)
+	if err != nil {
+		return waTypes.EmptyJID, "", &InvalidRecipientError{To: to, Reason: err.Error()}
+	}
+	return waTypes.EmptyJID, phone, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package whatsapp
+
+import (
+	"errors"
+	"testing"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+)
+
+func TestParseRecipient(t *testing.T) {
+	tests := []struct {
+		name, to  string
+		wantJID   waTypes.JID
+		wantPhone string
+	}{
+		{"e164 phone", "+55 11 99999-9999", waTypes.EmptyJID, "5511999999999"},
+		{"local phone", "11999999999", waTypes.EmptyJID, "5511999999999"},
+		{"whatsapp web jid", "5511999999999@c.us", waTypes.EmptyJID, "5511999999999"},
+		{"user jid", " 5511999999999@s.whatsapp.net ", waTypes.EmptyJID, "5511999999999"},
+		{"user jid with device", "5511999999999:12@s.whatsapp.net", waTypes.EmptyJID, "5511999999999"},
+		{"us user jid", "12025551234@s.whatsapp.net", waTypes.EmptyJID, "12025551234"},
+		{"group", "120363025246125888@g.us", waTypes.NewJID("120363025246125888", waTypes.GroupServer), ""},
+		{"legacy group", "5511999999999-1600000000@g.us", waTypes.NewJID("5511999999999-1600000000", waTypes.GroupServer), ""},
+		{"hidden user", "123456789012345@lid", waTypes.NewJID("123456789012345", waTypes.HiddenUserServer), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jid, phone, err := parseRecipient(tt.to, "55")
+			if err != nil {
+				t.Fatalf("parseRecipient(%q) error: %v", tt.to, err)
+			}
+			if jid != tt.wantJID || phone != tt.wantPhone {
+				t.Errorf("parseRecipient(%q) = %v, %q, want %v, %q", tt.to, jid, phone, tt.wantJID, tt.wantPhone)
+			}
+		})
+	}
+}
+
+func TestParseRecipientInvalid(t *testing.T) {
+	tests := []struct {
+		name, to, reason string
+	}{
+		{"empty", "  ", "empty recipient"},
+		{"letters", "call me", "invalid phone number"},
+		{"short phone", "1234", "invalid phone number"},
+		{"user jid with letters", "abc@s.whatsapp.net", "user part must be a phone number"},
+		{"group with letters", "abc@g.us", "malformed group id"},
+		{"legacy group with letters", "5511999999999-abc@g.us", "malformed group id"},
+		{"lid with letters", "abc@lid", "malformed lid"},
+		{"unknown server", "5511999999999@example.com", `unsupported server "example.com"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseRecipient(tt.to, "55")
+			var invalid *InvalidRecipientError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("parseRecipient(%q) error = %v, want InvalidRecipientError", tt.to, err)
+			}
+			if invalid.Reason != tt.reason {
+				t.Errorf("parseRecipient(%q) reason = %q, want %q", tt.to, invalid.Reason, tt.reason)
+			}
+		})
+	}
+}
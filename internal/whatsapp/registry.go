@@ -0,0 +1,108 @@
+package whatsapp
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// session is a loaded client with its connection history since it was
+// loaded.
+type session struct {
+	cli            *whatsmeow.Client
+	connectedAt    time.Time
+	disconnectedAt time.Time
+	lastError      string
+
+	// watchdog state, reset when the client connects again
+	attempts    int
+	nextAttempt time.Time
+	degraded    bool
+}
+
+// registry holds the loaded clients. It is safe for concurrent use.
+type registry struct {
+	mu       sync.RWMutex
+	sessions map[string]*session
+}
+
+func newRegistry() *registry {
+	return &registry{sessions: make(map[string]*session)}
+}
+
+func (r *registry) client(companyID string) (*whatsmeow.Client, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sess, ok := r.sessions[companyID]
+	if !ok {
+		return nil, false
+	}
+	return sess.cli, true
+}
+
+// add registers cli for the company unless another client got there first,
+// returning the client that ended up registered.
+func (r *registry) add(companyID string, cli *whatsmeow.Client) *whatsmeow.Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sess, ok := r.sessions[companyID]; ok {
+		return sess.cli
+	}
+	sess := &session{cli: cli}
+	if cli.IsConnected() {
+		sess.connectedAt = time.Now().UTC()
+	}
+	r.sessions[companyID] = sess
+	return cli
+}
+
+func (r *registry) remove(companyID string) {
+	r.mu.Lock()
+	delete(r.sessions, companyID)
+	r.mu.Unlock()
+}
+
+// drop removes the session of the company if cli is still its client,
+// reporting whether it did.
+func (r *registry) drop(companyID string, cli *whatsmeow.Client) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sess, ok := r.sessions[companyID]; ok && sess.cli == cli {
+		delete(r.sessions, companyID)
+		return true
+	}
+	return false
+}
+
+func (r *registry) ids() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]string, 0, len(r.sessions))
+	for id := range r.sessions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// update runs fn on the session of the company, if loaded.
+func (r *registry) update(companyID string, fn func(*session)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sess, ok := r.sessions[companyID]; ok {
+		fn(sess)
+	}
+}
+
+// snapshot returns a copy of the session of the company.
+func (r *registry) snapshot(companyID string) (session, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sess, ok := r.sessions[companyID]
+	if !ok {
+		return session{}, false
+	}
+	return *sess, true
+}
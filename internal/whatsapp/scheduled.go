@@ -0,0 +1,138 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/example/wpp-wave-bot/internal/scheduled"
+	"github.com/example/wpp-wave-bot/internal/tracing"
+)
+
+// Messages with a SendAt in the future are stored in scheduled_messages
+// until they are due. Every instance polls for due messages and publishes
+// them to wpp:send, and row locks keep two instances from dispatching the
+// same message.
+
+// scheduleBatch is how many due messages a poll dispatches at most.
+const scheduleBatch = 100
+
+// ScheduledEvent is published to wpp:results once a message consumed from
+// wpp:send was scheduled.
+type ScheduledEvent struct {
+	Event         string `json:"event"`
+	CompanyID     string `json:"company_id"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	*scheduled.Message
+}
+
+// Schedule stores a message to be sent at its SendAt, which must be in the
+// future.
+func (s *Service) Schedule(ctx context.Context, m *OutgoingMessage) (*scheduled.Message, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	if m.SendAt == nil || !m.SendAt.After(time.Now()) {
+		return nil, &ValidationError{Field: "send_at", Message: "must be in the future"}
+	}
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return s.schedRepo.Create(ctx, m.CompanyID, *m.SendAt, m.IdempotencyKey, payload)
+}
+
+// scheduleDelivery schedules a message consumed from wpp:send whose SendAt
+// is in the future, reporting false when it wasn't one. Invalid messages are
+// left to handleDelivery, which reports their failure.
+func (s *Service) scheduleDelivery(ctx context.Context, d amqp.Delivery) (handled, ok bool) {
+	var m OutgoingMessage
+	if err := json.Unmarshal(d.Body, &m); err != nil || m.SendAt == nil || !m.SendAt.After(time.Now()) || m.Validate() != nil {
+		return false, false
+	}
+	ctx, span := tracing.Start(tracing.Extract(ctx, d.Headers), "schedule wpp:send",
+		trace.WithSpanKind(trace.SpanKindConsumer), tracing.QueueAttrs("wpp:send"))
+	defer span.End()
+
+	sm, err := s.Schedule(ctx, &m)
+	if err != nil {
+		log.Error().Err(err).Str("company_id", m.CompanyID).Msg("failed to schedule message")
+		span.SetStatus(codes.Error, err.Error())
+		return true, false
+	}
+	evt := ScheduledEvent{
+		Event:         "message.scheduled",
+		CompanyID:     m.CompanyID,
+		CorrelationID: m.CorrelationID,
+		Message:       sm,
+	}
+	body, _ := json.Marshal(evt)
+	if err := s.mq.Publish(ctx, "", "wpp:results", body); err != nil {
+		log.Error().Err(err).Str("company_id", m.CompanyID).Msg("failed to publish result event")
+	}
+	s.emit(m.CompanyID, evt.Event, body)
+	return true, true
+}
+
+// scheduler dispatches the due scheduled messages every
+// Config.ScheduleInterval until ctx is done.
+func (s *Service) scheduler(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.ScheduleInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := s.schedRepo.Dispatch(ctx, scheduleBatch, s.dispatchScheduled)
+			if err != nil && ctx.Err() == nil {
+				log.Error().Err(err).Msg("failed to dispatch scheduled messages")
+			}
+			// a full batch may have left more due messages behind
+			if err != nil || n < scheduleBatch {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchScheduled publishes a due message to wpp:send. It goes out with
+// its idempotency key, or one derived from its ID, so that publishing it
+// again after a failed commit doesn't send it twice. Payloads that don't
+// decode to a valid message fail with a scheduled.PermanentError.
+func (s *Service) dispatchScheduled(ctx context.Context, sm scheduled.Message) error {
+	ctx, span := tracing.Start(ctx, "dispatch scheduled message", trace.WithAttributes(
+		attribute.String("company_id", sm.CompanyID),
+		attribute.String("scheduled_id", strconv.FormatInt(sm.ID, 10)),
+	))
+	defer span.End()
+
+	var m OutgoingMessage
+	err := json.Unmarshal(sm.Payload, &m)
+	if err == nil {
+		m.SendAt = nil
+		m.IdempotencyKey = sm.Key
+		err = m.Validate()
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		log.Error().Err(err).Str("company_id", sm.CompanyID).Int64("scheduled_id", sm.ID).Msg("scheduled message can't be dispatched")
+		return &scheduled.PermanentError{Err: err}
+	}
+	body, _ := json.Marshal(m)
+	if err := s.mq.Publish(ctx, "", "wpp:send", body); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	log.Info().Str("company_id", sm.CompanyID).Int64("scheduled_id", sm.ID).Msg("scheduled message dispatched")
+	return nil
+}
@@ -0,0 +1,37 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/example/wpp-wave-bot/internal/scheduled"
+)
+
+func TestDispatchScheduledRejectsBadPayloads(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+	}{
+		{"not json", `{"company_id": "empresa-123", "to":`},
+		{"wrong types", `{"company_id": 123, "to": "5511999999999", "type": "text", "message": "Olá"}`},
+		{"invalid message", `{"company_id": "empresa-123", "to": "5511999999999", "type": "text"}`},
+		{"unknown type", `{"company_id": "empresa-123", "to": "5511999999999", "type": "sticker"}`},
+	}
+	s := &Service{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.dispatchScheduled(context.Background(), scheduled.Message{
+				ID:        7,
+				CompanyID: "empresa-123",
+				Payload:   json.RawMessage(tt.payload),
+				Key:       "scheduled:7",
+			})
+			var permanent *scheduled.PermanentError
+			if !errors.As(err, &permanent) {
+				t.Errorf("dispatchScheduled = %v, want a PermanentError", err)
+			}
+		})
+	}
+}
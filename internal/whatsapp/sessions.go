@@ -0,0 +1,286 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	waEvents "go.mau.fi/whatsmeow/types/events"
+
+	"github.com/example/wpp-wave-bot/internal/sessions"
+)
+
+// SessionStatus describes the session of a company, combining the stored
+// device with the state of its loaded client.
+type SessionStatus struct {
+	CompanyID string `json:"company_id"`
+	// Loaded is set when the session has a client in memory.
+	Loaded    bool `json:"loaded"`
+	Connected bool `json:"connected"`
+	LoggedIn  bool `json:"logged_in"`
+	// Paired is set once a device was linked by scanning the QR code.
+	Paired             bool       `json:"paired"`
+	JID                string     `json:"jid,omitempty"`
+	PushName           string     `json:"push_name,omitempty"`
+	Platform           string     `json:"platform,omitempty"`
+	PairedAt           *time.Time `json:"paired_at,omitempty"`
+	LastConnectedAt    *time.Time `json:"last_connected_at,omitempty"`
+	LastDisconnectedAt *time.Time `json:"last_disconnected_at,omitempty"`
+	LastError          string     `json:"last_error,omitempty"`
+	// BannedUntil is set while WhatsApp temporarily bans the device.
+	BannedUntil *time.Time `json:"banned_until,omitempty"`
+	// Degraded is set when the session is down beyond its SLA.
+	Degraded          bool `json:"degraded"`
+	ReconnectAttempts int  `json:"reconnect_attempts"`
+	// Owner is the instance holding the session lease, which runs the
+	// client.
+	Owner string `json:"owner,omitempty"`
+	// RateLimit is set when companies have a rate limit.
+	RateLimit *RateLimitStatus `json:"rate_limit,omitempty"`
+}
+
+// Status reports the state of the company's session, failing with
+// ErrSessionNotFound when it is neither loaded nor paired.
+func (s *Service) Status(ctx context.Context, companyID string) (*SessionStatus, error) {
+	stored, err := s.sessionRepo.Get(ctx, companyID)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := s.leaseRepo.Owner(ctx, companyID)
+	if err != nil {
+		return nil, err
+	}
+	st := s.status(companyID, stored, owner)
+	if st == nil {
+		return nil, ErrSessionNotFound
+	}
+	return st, nil
+}
+
+// ListSessions reports the state of every loaded or paired session.
+func (s *Service) ListSessions(ctx context.Context) ([]SessionStatus, error) {
+	stored, err := s.sessionRepo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	owners, err := s.leaseRepo.Owners(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byCompany := make(map[string]*sessions.Session, len(stored))
+	for i := range stored {
+		byCompany[stored[i].CompanyID] = &stored[i]
+	}
+	for _, id := range s.clients.ids() {
+		if _, ok := byCompany[id]; !ok {
+			byCompany[id] = nil
+		}
+	}
+
+	list := make([]SessionStatus, 0, len(byCompany))
+	for id, sess := range byCompany {
+		if st := s.status(id, sess, owners[id]); st != nil {
+			list = append(list, *st)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CompanyID < list[j].CompanyID })
+	return list, nil
+}
+
+// CheckStore verifies the whatsmeow device store can be queried.
+func (s *Service) CheckStore(ctx context.Context) error {
+	_, err := s.store.GetFirstDevice(ctx)
+	return err
+}
+
+// Connectivity summarizes the loaded sessions.
+type Connectivity struct {
+	Loaded       int      `json:"loaded"`
+	Connected    int      `json:"connected"`
+	Disconnected []string `json:"disconnected"`
+	Degraded     []string `json:"degraded"`
+}
+
+// Connectivity reports how many loaded sessions are connected, listing the
+// ones that aren't.
+func (s *Service) Connectivity() Connectivity {
+	c := Connectivity{Disconnected: []string{}, Degraded: []string{}}
+	for _, id := range s.clients.ids() {
+		sess, ok := s.clients.snapshot(id)
+		if !ok {
+			continue
+		}
+		c.Loaded++
+		if sess.cli.IsConnected() {
+			c.Connected++
+		} else {
+			c.Disconnected = append(c.Disconnected, id)
+		}
+		if sess.degraded {
+			c.Degraded = append(c.Degraded, id)
+		}
+	}
+	return c
+}
+
+// status merges the stored session, if any, with the loaded client and the
+// lease owner. It returns nil when there is neither session nor client.
+func (s *Service) status(companyID string, stored *sessions.Session, owner string) *SessionStatus {
+	loaded, ok := s.clients.snapshot(companyID)
+	if stored == nil && !ok {
+		return nil
+	}
+
+	st := &SessionStatus{CompanyID: companyID, Owner: owner, RateLimit: s.limiter.status(companyID)}
+	if stored != nil {
+		st.Paired = true
+		st.JID = stored.JID
+		st.PushName = stored.PushName
+		st.Platform = stored.Platform
+		st.PairedAt = stored.PairedAt
+		st.LastConnectedAt = stored.ConnectedAt
+		st.LastDisconnectedAt = stored.DisconnectedAt
+		st.LastError = stored.LastError
+		if stored.BannedUntil != nil && time.Now().Before(*stored.BannedUntil) {
+			st.BannedUntil = stored.BannedUntil
+		}
+	}
+	if !ok {
+		return st
+	}
+
+	cli := loaded.cli
+	st.Loaded = true
+	st.Connected = cli.IsConnected()
+	st.LoggedIn = cli.IsLoggedIn()
+	if cli.Store.ID != nil {
+		st.Paired = true
+		st.JID = cli.Store.ID.String()
+	}
+	if cli.Store.PushName != "" {
+		st.PushName = cli.Store.PushName
+	}
+	if cli.Store.Platform != "" {
+		st.Platform = cli.Store.Platform
+	}
+	if !loaded.connectedAt.IsZero() {
+		st.LastConnectedAt = &loaded.connectedAt
+	}
+	if !loaded.disconnectedAt.IsZero() {
+		st.LastDisconnectedAt = &loaded.disconnectedAt
+	}
+	if loaded.lastError != "" {
+		st.LastError = loaded.lastError
+	}
+	st.Degraded = loaded.degraded
+	st.ReconnectAttempts = loaded.attempts
+	return st
+}
+
+func (s *Service) markConnected(companyID string, cli *whatsmeow.Client) {
+	now := time.Now().UTC()
+	s.clients.update(companyID, func(sess *session) {
+		sess.connectedAt = now
+		sess.lastError = ""
+		sess.attempts = 0
+		sess.nextAttempt = time.Time{}
+		sess.degraded = false
+	})
+	if err := s.sessionRepo.MarkConnected(context.Background(), companyID, cli.Store.PushName, cli.Store.Platform); err != nil {
+		log.Error().Err(err).Str("company_id", companyID).Msg("failed to update session")
+	}
+}
+
+func (s *Service) markDisconnected(companyID string) {
+	now := time.Now().UTC()
+	s.clients.update(companyID, func(sess *session) {
+		sess.disconnectedAt = now
+	})
+	if err := s.sessionRepo.MarkDisconnected(context.Background(), companyID); err != nil {
+		log.Error().Err(err).Str("company_id", companyID).Msg("failed to update session")
+	}
+}
+
+// recordError keeps err as the last error of the company's session.
+func (s *Service) recordError(companyID string, err error) {
+	s.clients.update(companyID, func(sess *session) {
+		sess.lastError = err.Error()
+	})
+	if err := s.sessionRepo.SetError(context.Background(), companyID, err.Error()); err != nil {
+		log.Error().Err(err).Str("company_id", companyID).Msg("failed to update session")
+	}
+}
+
+// The handlers below cover the states whatsmeow doesn't recover from on its
+// own. The client is unloaded so the next Connect or Send starts over, and
+// nothing reconnects automatically.
+
+// handleLoggedOut forgets a device that was unlinked from the phone.
+// whatsmeow already deleted it from its store.
+func (s *Service) handleLoggedOut(companyID string, cli *whatsmeow.Client, evt *waEvents.LoggedOut) {
+	reason := "device removed"
+	if evt.OnConnect {
+		reason = evt.Reason.String()
+	}
+	log.Warn().Str("company_id", companyID).Str("reason", reason).Msg("client logged out")
+	s.unload(companyID, cli)
+	if err := s.sessionRepo.Delete(context.Background(), companyID); err != nil {
+		log.Error().Err(err).Str("company_id", companyID).Msg("failed to delete session")
+	}
+	s.disown(companyID, true)
+	s.publishSessionEvent(SessionEvent{CompanyID: companyID, Status: "logged_out", Reason: reason})
+}
+
+// handleStreamReplaced gives the session up to the client that took it
+// over, which is usually another instance using the same device.
+func (s *Service) handleStreamReplaced(companyID string, cli *whatsmeow.Client) {
+	const reason = "stream replaced by another connection"
+	log.Warn().Str("company_id", companyID).Msg(reason)
+	s.recordError(companyID, errors.New(reason))
+	s.unload(companyID, cli)
+	s.publishSessionEvent(SessionEvent{CompanyID: companyID, Status: "stream_replaced", Reason: reason})
+}
+
+// handleTemporaryBan keeps the device but refuses to reconnect it until the
+// ban expires.
+func (s *Service) handleTemporaryBan(companyID string, cli *whatsmeow.Client, evt *waEvents.TemporaryBan) {
+	reason := evt.Code.String()
+	log.Warn().Str("company_id", companyID).Str("reason", reason).Dur("expire", evt.Expire).Msg("client temporarily banned")
+	var expiresAt *time.Time
+	if evt.Expire > 0 {
+		t := time.Now().UTC().Add(evt.Expire)
+		expiresAt = &t
+		if err := s.sessionRepo.SetBanned(context.Background(), companyID, t, "temporarily banned: "+reason); err != nil {
+			log.Error().Err(err).Str("company_id", companyID).Msg("failed to update session")
+		}
+	} else {
+		s.recordError(companyID, errors.New("temporarily banned: "+reason))
+	}
+	s.unload(companyID, cli)
+	s.publishSessionEvent(SessionEvent{CompanyID: companyID, Status: "temporary_ban", Reason: reason, ExpiresAt: expiresAt})
+}
+
+// handleConnectFailure handles connection failures whatsmeow doesn't retry.
+func (s *Service) handleConnectFailure(companyID string, cli *whatsmeow.Client, evt *waEvents.ConnectFailure) {
+	reason := evt.Reason.String()
+	if evt.Message != "" {
+		reason += " (" + evt.Message + ")"
+	}
+	log.Error().Str("company_id", companyID).Str("reason", reason).Msg("connect failure")
+	s.recordError(companyID, errors.New("connect failure: "+reason))
+	s.unload(companyID, cli)
+	s.publishSessionEvent(SessionEvent{CompanyID: companyID, Status: "connect_failure", Reason: reason})
+}
+
+// unload disconnects cli and removes it from the registry, unless another
+// client replaced it there meanwhile.
+func (s *Service) unload(companyID string, cli *whatsmeow.Client) {
+	cli.EnableAutoReconnect = false
+	cli.Disconnect()
+	if s.clients.drop(companyID, cli) {
+		s.markDisconnected(companyID)
+	}
+}
@@ -0,0 +1,139 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand/v2"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+
+	"github.com/example/wpp-wave-bot/internal/metrics"
+)
+
+// DegradedEvent is published to wpp:sessions when a session stays
+// disconnected for longer than Config.DegradedAfter.
+type DegradedEvent struct {
+	Event             string    `json:"event"`
+	CompanyID         string    `json:"company_id"`
+	DisconnectedAt    time.Time `json:"disconnected_at"`
+	ReconnectAttempts int       `json:"reconnect_attempts"`
+}
+
+// watchdog checks the loaded sessions every Config.WatchdogInterval until
+// ctx is done.
+func (s *Service) watchdog(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.WatchdogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, id := range s.clients.ids() {
+				s.checkSession(id, now.UTC())
+			}
+		}
+	}
+}
+
+// checkSession escalates a session that is down beyond its SLA and forces a
+// fresh connection once its backoff elapsed. whatsmeow reconnects on its own,
+// so the first forced attempt only happens after the minimum backoff.
+func (s *Service) checkSession(companyID string, now time.Time) {
+	var (
+		cli       *whatsmeow.Client
+		degraded  *DegradedEvent
+		reconnect bool
+	)
+	s.clients.update(companyID, func(sess *session) {
+		cli = sess.cli
+		degraded, reconnect = s.watch(companyID, sess, cli.Store.ID != nil, cli.IsConnected(), now)
+	})
+
+	if degraded != nil {
+		log.Warn().Str("company_id", companyID).Time("disconnected_at", degraded.DisconnectedAt).Msg("session degraded")
+		s.publishDegraded(*degraded)
+	}
+	if reconnect {
+		go s.reconnect(companyID, cli)
+	}
+}
+
+// watch updates the watchdog state of a session, returning the event to
+// publish when it just became degraded and whether to force a reconnect.
+// Sessions that are connected or logged out are left alone.
+func (s *Service) watch(companyID string, sess *session, paired, connected bool, now time.Time) (*DegradedEvent, bool) {
+	if !paired || connected {
+		return nil, false
+	}
+	since := sess.disconnectedAt
+	if since.IsZero() {
+		since = sess.connectedAt
+	}
+	if since.IsZero() {
+		return nil, false
+	}
+	var degraded *DegradedEvent
+	if !sess.degraded && now.Sub(since) >= s.cfg.DegradedAfter {
+		sess.degraded = true
+		degraded = &DegradedEvent{
+			Event:             "session.degraded",
+			CompanyID:         companyID,
+			DisconnectedAt:    since,
+			ReconnectAttempts: sess.attempts,
+		}
+	}
+	if sess.nextAttempt.IsZero() {
+		sess.nextAttempt = since.Add(s.backoff(0))
+	}
+	if now.Before(sess.nextAttempt) {
+		return degraded, false
+	}
+	sess.attempts++
+	sess.nextAttempt = now.Add(s.backoff(sess.attempts))
+	return degraded, true
+}
+
+// reconnect replaces the connection of cli with a fresh one.
+func (s *Service) reconnect(companyID string, cli *whatsmeow.Client) {
+	log.Info().Str("company_id", companyID).Msg("forcing reconnect")
+	metrics.SessionReconnects.WithLabelValues(companyID).Inc()
+	cli.Disconnect()
+	if err := cli.Connect(); err != nil {
+		log.Error().Err(err).Str("company_id", companyID).Msg("forced reconnect failed")
+		s.recordError(companyID, err)
+	}
+}
+
+// backoff returns the delay before the given reconnect attempt, doubling
+// from ReconnectBackoffMin up to ReconnectBackoffMax with up to half of it
+// as jitter.
+func (s *Service) backoff(attempt int) time.Duration {
+	d := s.cfg.ReconnectBackoffMin
+	for i := 0; i < attempt && d < s.cfg.ReconnectBackoffMax; i++ {
+		d *= 2
+	}
+	d = min(d, s.cfg.ReconnectBackoffMax)
+	return d/2 + rand.N(d/2+1)
+}
+
+// Degraded returns the companies whose session is down beyond its SLA.
+func (s *Service) Degraded() []string {
+	var list []string
+	for _, id := range s.clients.ids() {
+		if sess, ok := s.clients.snapshot(id); ok && sess.degraded {
+			list = append(list, id)
+		}
+	}
+	return list
+}
+
+func (s *Service) publishDegraded(evt DegradedEvent) {
+	body, _ := json.Marshal(evt)
+	if err := s.mq.Publish(context.Background(), "", "wpp:sessions", body); err != nil {
+		log.Error().Err(err).Msg("failed to publish session event")
+	}
+	s.emit(evt.CompanyID, evt.Event, body)
+}
@@ -0,0 +1,96 @@
+package whatsapp
+
+import (
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store"
+)
+
+func TestBackoff(t *testing.T) {
+	s := &Service{cfg: Config{ReconnectBackoffMin: 10 * time.Second, ReconnectBackoffMax: 5 * time.Minute}}
+	tests := []struct {
+		attempt int
+		base    time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 20 * time.Second},
+		{2, 40 * time.Second},
+		{4, 160 * time.Second},
+		{5, 5 * time.Minute},
+		{30, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		// the jitter takes up to half of the delay
+		for range 100 {
+			if d := s.backoff(tt.attempt); d < tt.base/2 || d > tt.base {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", tt.attempt, d, tt.base/2, tt.base)
+			}
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		sess      session
+		paired    bool
+		connected bool
+		degraded  bool
+		reconnect bool
+	}{
+		{"connected", session{disconnectedAt: now.Add(-time.Hour)}, true, true, false, false},
+		{"logged out", session{disconnectedAt: now.Add(-time.Hour)}, false, false, false, false},
+		{"never connected", session{}, true, false, false, false},
+		{"within the first backoff", session{disconnectedAt: now.Add(-time.Second)}, true, false, false, false},
+		{"disconnected", session{disconnectedAt: now.Add(-time.Minute)}, true, false, false, true},
+		{"down since connecting", session{connectedAt: now.Add(-time.Minute)}, true, false, false, true},
+		{"beyond the SLA", session{disconnectedAt: now.Add(-10 * time.Minute)}, true, false, true, true},
+		{"already degraded", session{disconnectedAt: now.Add(-10 * time.Minute), degraded: true, attempts: 3}, true, false, false, true},
+		{"waiting for the next attempt", session{disconnectedAt: now.Add(-time.Minute), attempts: 1, nextAttempt: now.Add(time.Second)}, true, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{cfg: Config{DegradedAfter: 5 * time.Minute, ReconnectBackoffMin: 10 * time.Second, ReconnectBackoffMax: 5 * time.Minute}}
+			sess := tt.sess
+			degraded, reconnect := s.watch("empresa-123", &sess, tt.paired, tt.connected, now)
+			if (degraded != nil) != tt.degraded {
+				t.Errorf("degraded event = %+v, want %v", degraded, tt.degraded)
+			}
+			if reconnect != tt.reconnect {
+				t.Errorf("reconnect = %v, want %v", reconnect, tt.reconnect)
+			}
+			if reconnect {
+				if sess.attempts != tt.sess.attempts+1 {
+					t.Errorf("attempts = %d, want %d", sess.attempts, tt.sess.attempts+1)
+				}
+				if !sess.nextAttempt.After(now) {
+					t.Errorf("next attempt %s isn't after the forced one", sess.nextAttempt)
+				}
+			}
+			if degraded != nil && (degraded.Event != "session.degraded" || !degraded.DisconnectedAt.Equal(tt.sess.disconnectedAt)) {
+				t.Errorf("degraded event = %+v", degraded)
+			}
+		})
+	}
+}
+
+func TestCheckSessionSkipsLoggedOut(t *testing.T) {
+	s := &Service{
+		cfg:     Config{DegradedAfter: 5 * time.Minute, ReconnectBackoffMin: 10 * time.Second, ReconnectBackoffMax: 5 * time.Minute},
+		clients: newRegistry(),
+	}
+	// a client without a device ID was logged out or never paired
+	s.clients.add("empresa-123", whatsmeow.NewClient(&store.Device{}, nil))
+	now := time.Now().UTC()
+	s.clients.update("empresa-123", func(sess *session) { sess.disconnectedAt = now.Add(-time.Hour) })
+
+	s.checkSession("empresa-123", now)
+
+	sess, _ := s.clients.snapshot("empresa-123")
+	if sess.attempts != 0 || sess.degraded || !sess.nextAttempt.IsZero() {
+		t.Errorf("logged out session watched: %+v", sess)
+	}
+}
@@ -3,13 +3,20 @@ package whatsapp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/encoding/protojson"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -20,12 +27,19 @@ import (
 	"go.mau.fi/whatsmeow/store/sqlstore"
 	waTypes "go.mau.fi/whatsmeow/types"
 	waEvents "go.mau.fi/whatsmeow/types/events"
-	waLog "go.mau.fi/whatsmeow/util/log"
 
 	"github.com/example/wpp-wave-bot/internal/contacts"
+	"github.com/example/wpp-wave-bot/internal/events"
 	"github.com/example/wpp-wave-bot/internal/groups"
+	"github.com/example/wpp-wave-bot/internal/leases"
+	"github.com/example/wpp-wave-bot/internal/logging"
 	"github.com/example/wpp-wave-bot/internal/messages"
+	"github.com/example/wpp-wave-bot/internal/metrics"
 	"github.com/example/wpp-wave-bot/internal/rabbitmq"
+	"github.com/example/wpp-wave-bot/internal/scheduled"
+	"github.com/example/wpp-wave-bot/internal/sessions"
+	"github.com/example/wpp-wave-bot/internal/tracing"
+	"github.com/example/wpp-wave-bot/internal/webhooks"
 )
 
 // OutgoingMessage represents a message consumed from the queue to be sent.
@@ -36,6 +50,36 @@ type OutgoingMessage struct {
 	Message   string `json:"message"`
 	MediaURL  string `json:"media_url"`
 	Filename  string `json:"filename"`
+	// IdempotencyKey makes repeated submissions return the original message
+	// instead of sending it again.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// CorrelationID is echoed back in the result event of queued messages.
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// SendAt schedules the message for later when it is in the future.
+	SendAt *time.Time `json:"send_at,omitempty"`
+}
+
+// SendResult describes the outcome of a send request.
+type SendResult struct {
+	// ID is the row ID of the message in the messages table.
+	ID        int64     `json:"id"`
+	MsgID     string    `json:"msg_id"`
+	Recipient string    `json:"recipient"`
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+	// Duplicate is set when the idempotency key matched an earlier message
+	// and nothing was sent.
+	Duplicate bool `json:"duplicate"`
+}
+
+// ResultEvent is published to wpp:results once a message consumed from
+// wpp:send was sent or failed.
+type ResultEvent struct {
+	Event         string `json:"event"`
+	CompanyID     string `json:"company_id"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	*SendResult
+	Error string `json:"error,omitempty"`
 }
 
 // IncomingMessage represents a received WhatsApp message published to the queue.
@@ -47,117 +91,440 @@ type IncomingMessage struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// StatusEvent reports a delivery status change of an outgoing message. For
+// groups a separate event is emitted for every participant.
+type StatusEvent struct {
+	Event       string    `json:"event"`
+	MsgID       string    `json:"msg_id"`
+	CompanyID   string    `json:"company_id"`
+	Recipient   string    `json:"recipient"`
+	Participant string    `json:"participant,omitempty"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// SessionEvent is published to wpp:sessions when the state of a session
+// changes. Reason explains terminal states such as logged_out or
+// temporary_ban, and ExpiresAt is set when a ban has a known end.
+type SessionEvent struct {
+	CompanyID string     `json:"company_id"`
+	Status    string     `json:"status"`
+	Code      string     `json:"code,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Config holds tunables for the WhatsApp service.
+type Config struct {
+	// DefaultCountry is the calling code assumed for phone numbers without
+	// an international prefix, e.g. "55".
+	DefaultCountry string
+	// NumberCheckTTL is how long registration lookups are cached.
+	NumberCheckTTL time.Duration
+	// WatchdogInterval is how often loaded sessions are checked.
+	WatchdogInterval time.Duration
+	// DegradedAfter is how long a session may stay disconnected before a
+	// session.degraded event is published.
+	DegradedAfter time.Duration
+	// ReconnectBackoffMin and ReconnectBackoffMax bound the delay between
+	// forced reconnects of a disconnected session.
+	ReconnectBackoffMin time.Duration
+	ReconnectBackoffMax time.Duration
+	// DrainTimeout is how long an in-flight send may take after shutdown
+	// begins.
+	DrainTimeout time.Duration
+	// InstanceID identifies this instance in session leases. It must be
+	// unique among the running instances and defaults to the hostname.
+	InstanceID string
+	// LeaseTTL is how long a session lease lasts without being renewed,
+	// which bounds how long a dead instance keeps its sessions.
+	LeaseTTL time.Duration
+	// Limits throttle outgoing messages.
+	Limits Limits
+	// ScheduleInterval is how often due scheduled messages are dispatched.
+	ScheduleInterval time.Duration
+}
+
 // Service manages WhatsApp sessions and message flow.
 type Service struct {
+	cfg     Config
 	db      *pgxpool.Pool
-	mq      *rabbitmq.RabbitMQ
+	mq      broker
+	rpc     *rabbitmq.RPC
+	hooks   *webhooks.Dispatcher
+	events  *events.Hub
 	store   *sqlstore.Container
-	clients map[string]*whatsmeow.Client
+	clients *registry
+	owned   *ownership
+	limiter *limiter
 
 	msgRepo     *messages.Repository
 	contactRepo *contacts.Repository
 	groupRepo   *groups.Repository
+	sessionRepo sessionStore
+	leaseRepo   leaseStore
+	schedRepo   *scheduled.Repository
 }
 
-// Sessions returns the list of company IDs with active clients.
-func (s *Service) Sessions() []string {
-	ids := make([]string, 0, len(s.clients))
-	for id := range s.clients {
-		ids = append(ids, id)
-	}
-	return ids
+// broker is the part of rabbitmq.RabbitMQ the service uses.
+type broker interface {
+	Declare(queue string) error
+	Consume(queue string) (string, <-chan amqp.Delivery, error)
+	Subscribe(exchange, key string) (string, <-chan amqp.Delivery, error)
+	Cancel(tag string) error
+	Publish(ctx context.Context, exchange, key string, body []byte) error
+	Broadcast(ctx context.Context, exchange, origin string, body []byte) error
+	Forward(ctx context.Context, queue string, d amqp.Delivery) error
+}
+
+// leaseStore is the part of leases.Repository the service uses.
+type leaseStore interface {
+	Acquire(ctx context.Context, companyID, owner string, ttl time.Duration) (bool, error)
+	Renew(ctx context.Context, owner string, companyIDs []string, ttl time.Duration) ([]string, error)
+	Release(ctx context.Context, companyID, owner string) error
+	ReleaseAll(ctx context.Context, owner string) error
+	Owner(ctx context.Context, companyID string) (string, error)
+	Owners(ctx context.Context) (map[string]string, error)
+	Orphaned(ctx context.Context) ([]string, error)
+}
+
+// sessionStore is the part of sessions.Repository the service uses.
+type sessionStore interface {
+	Get(ctx context.Context, companyID string) (*sessions.Session, error)
+	List(ctx context.Context) ([]sessions.Session, error)
+	SavePaired(ctx context.Context, companyID, jid, platform string) error
+	MarkConnected(ctx context.Context, companyID, pushName, platform string) error
+	MarkDisconnected(ctx context.Context, companyID string) error
+	SetError(ctx context.Context, companyID, msg string) error
+	SetBanned(ctx context.Context, companyID string, until time.Time, msg string) error
+	Delete(ctx context.Context, companyID string) error
 }
 
 // Logout disconnects the client's session and removes it from storage.
 func (s *Service) Logout(ctx context.Context, companyID string) error {
-	cli, ok := s.clients[companyID]
+	err := s.logoutLocal(ctx, companyID)
+	if errors.Is(err, ErrSessionOwnedElsewhere) {
+		return s.forward(ctx, methodLogout, forwardRequest{CompanyID: companyID}, nil)
+	}
+	return err
+}
+
+func (s *Service) logoutLocal(ctx context.Context, companyID string) error {
+	cli, ok := s.clients.client(companyID)
 	if !ok {
-		return fmt.Errorf("session not found")
+		if err := s.checkOwner(ctx, companyID); err != nil {
+			return err
+		}
+		return ErrSessionNotFound
 	}
 	if err := cli.Logout(ctx); err != nil {
 		return err
 	}
-	delete(s.clients, companyID)
-	_, err := s.db.Exec(ctx, "DELETE FROM sessions WHERE company_id=$1", companyID)
-	return err
+	s.clients.remove(companyID)
+	if err := s.sessionRepo.Delete(ctx, companyID); err != nil {
+		return err
+	}
+	s.disown(companyID, true)
+	return nil
+}
+
+// Subscribe streams the events of companyID, or of every company when it is
+// empty, until the returned function is called.
+func (s *Service) Subscribe(companyID string) (<-chan events.Event, func()) {
+	return s.events.Subscribe(companyID)
 }
 
 // Connect ensures a client for the company is connected. When authentication is
 // required, the first QR code string is returned so it can be rendered to the
-// user.
+// user. Sessions owned by another instance are connected there.
 func (s *Service) Connect(ctx context.Context, companyID string) (string, error) {
+	qr, err := s.connectLocal(ctx, companyID)
+	if errors.Is(err, ErrSessionOwnedElsewhere) {
+		err = s.forward(ctx, methodConnect, forwardRequest{CompanyID: companyID}, &qr)
+	}
+	return qr, err
+}
+
+func (s *Service) connectLocal(ctx context.Context, companyID string) (string, error) {
 	_, qr, err := s.getClient(ctx, companyID)
 	return qr, err
 }
 
-// Send dispatches a message immediately using WhatsApp.
-func (s *Service) Send(ctx context.Context, msg *OutgoingMessage) error {
+// Send dispatches a message immediately using WhatsApp, through the instance
+// owning the company's session.
+func (s *Service) Send(ctx context.Context, msg *OutgoingMessage) (*SendResult, error) {
+	res, err := s.sendLocal(ctx, msg)
+	if errors.Is(err, ErrSessionOwnedElsewhere) {
+		var fwd SendResult
+		if err := s.forward(ctx, methodSend, forwardRequest{CompanyID: msg.CompanyID, Message: msg}, &fwd); err != nil {
+			return nil, err
+		}
+		return &fwd, nil
+	}
+	return res, err
+}
+
+func (s *Service) sendLocal(ctx context.Context, msg *OutgoingMessage) (*SendResult, error) {
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
 	cli, _, err := s.getClient(ctx, msg.CompanyID)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return s.sendMessage(ctx, cli, msg)
+	return s.sendMessage(ctx, cli, msg, s.cfg.Limits.MaxWait)
 }
 
 // New creates a new Service instance using the given Postgres URL for the whatsmeow store.
-func New(db *pgxpool.Pool, dbURL string, mq *rabbitmq.RabbitMQ) (*Service, error) {
-	container, err := sqlstore.New(context.Background(), "pgx", dbURL, waLog.Noop)
+func New(db *pgxpool.Pool, dbURL string, mq *rabbitmq.RabbitMQ, hooks *webhooks.Dispatcher, cfg Config) (*Service, error) {
+	if cfg.WatchdogInterval <= 0 {
+		cfg.WatchdogInterval = 30 * time.Second
+	}
+	if cfg.DegradedAfter <= 0 {
+		cfg.DegradedAfter = 5 * time.Minute
+	}
+	if cfg.ReconnectBackoffMin <= 0 {
+		cfg.ReconnectBackoffMin = 10 * time.Second
+	}
+	if cfg.DrainTimeout <= 0 {
+		cfg.DrainTimeout = 20 * time.Second
+	}
+	if cfg.LeaseTTL <= 0 {
+		cfg.LeaseTTL = 30 * time.Second
+	}
+	if cfg.InstanceID == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("instance id: %w", err)
+		}
+		cfg.InstanceID = host
+	}
+	if cfg.ScheduleInterval <= 0 {
+		cfg.ScheduleInterval = 5 * time.Second
+	}
+	if cfg.Limits.MaxWait <= 0 {
+		cfg.Limits.MaxWait = 10 * time.Second
+	}
+	if cfg.Limits.JitterMax < cfg.Limits.JitterMin {
+		cfg.Limits.JitterMax = cfg.Limits.JitterMin
+	}
+	if cfg.ReconnectBackoffMax < cfg.ReconnectBackoffMin {
+		cfg.ReconnectBackoffMax = max(5*time.Minute, cfg.ReconnectBackoffMin)
+	}
+	container, err := sqlstore.New(context.Background(), "pgx", dbURL, logging.WA(log.With().Str("module", "store").Logger()))
+	if err != nil {
+		return nil, err
+	}
+	// publishing to a missing exchange closes the channel, so it must exist
+	// before the first event
+	if err := mq.DeclareFanout(eventsExchange); err != nil {
+		return nil, fmt.Errorf("declare %s: %w", eventsExchange, err)
+	}
+	rpc, err := mq.NewRPC(rpcExchange, cfg.InstanceID)
 	if err != nil {
 		return nil, err
 	}
 	return &Service{
+		cfg:         cfg,
 		db:          db,
 		mq:          mq,
+		rpc:         rpc,
+		hooks:       hooks,
+		events:      events.NewHub(),
 		store:       container,
-		clients:     make(map[string]*whatsmeow.Client),
+		clients:     newRegistry(),
+		owned:       newOwnership(),
+		limiter:     newLimiter(cfg.Limits),
 		msgRepo:     messages.NewRepository(db),
 		contactRepo: contacts.NewRepository(db),
 		groupRepo:   groups.NewRepository(db),
+		sessionRepo: sessions.NewRepository(db),
+		leaseRepo:   leases.NewRepository(db),
+		schedRepo:   scheduled.NewRepository(db),
 	}, nil
 }
 
-// Start begins consuming messages from RabbitMQ.
+// eventQueues are the queues events are published to.
+var eventQueues = []string{"wpp:status", "wpp:results"}
+
+// eventsExchange copies the events of every instance to all of them, so
+// streaming clients connected to one instance get the events of sessions
+// running on the others.
+const eventsExchange = "wpp:events"
+
+// Start begins consuming messages from RabbitMQ, keeping the session leases
+// of this instance, dispatching due scheduled messages and watching its
+// sessions. Messages published to wpp:send are routed to the send queue of
+// their company, which only the owner of the company's lease consumes, the
+// calls other instances forward for the owned sessions are answered and the
+// events of other instances are relayed to the streaming subscribers.
+// Once ctx is done it stops consuming and returns after the messages being
+// sent, if any, finish. Those sends are cancelled after Config.DrainTimeout
+// and their messages requeued.
 func (s *Service) Start(ctx context.Context) error {
-	msgs, err := s.mq.Consume("wpp:send")
+	// Unlike the queues shared with the orchestrator, the event queues are
+	// ours to create, or the broker drops what is published to them.
+	for _, queue := range eventQueues {
+		if err := s.mq.Declare(queue); err != nil {
+			return fmt.Errorf("declare %s: %w", queue, err)
+		}
+	}
+	tag, relayed, err := s.mq.Subscribe(eventsExchange, "")
 	if err != nil {
-		return err
+		return fmt.Errorf("subscribe to %s: %w", eventsExchange, err)
 	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	work, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+	context.AfterFunc(ctx, func() {
+		time.AfterFunc(s.cfg.DrainTimeout, cancelWork)
+	})
 
+	s.owned.start(ctx, work, s.consumeCompany)
+	go s.watchdog(ctx)
+	go s.keepLeases(ctx)
+	go s.scheduler(ctx)
+	go s.relayEvents(ctx, tag, relayed)
+	go func() {
+		if err := s.rpc.Serve(ctx, work, s.serveForwarded); err != nil {
+			log.Error().Err(err).Msg("stopped answering forwarded calls")
+		}
+	}()
+
+	err = s.consume(ctx, work, "wpp:send", s.route)
+	// stop the send queue consumers too and wait for their sends
+	cancel()
+	s.owned.wait()
+	return err
+}
+
+// consume handles the deliveries of queue one at a time until ctx is done,
+// passing work to handle. A delivery is acknowledged when handle reports it
+// done and requeued otherwise, as are the ones prefetched when consuming
+// stops.
+func (s *Service) consume(ctx, work context.Context, queue string, handle func(context.Context, amqp.Delivery) bool) error {
+	tag, msgs, err := s.mq.Consume(queue)
+	if err != nil {
+		return err
+	}
 	for {
 		select {
 		case <-ctx.Done():
-			return nil
-		case d := <-msgs:
-			var m OutgoingMessage
-			if err := json.Unmarshal(d.Body, &m); err != nil {
-				log.Error().Err(err).Msg("invalid message payload")
-				continue
+			if err := s.mq.Cancel(tag); err != nil {
+				log.Error().Err(err).Str("queue", queue).Msg("failed to stop consuming")
+				return nil
 			}
-			cli, _, err := s.getClient(ctx, m.CompanyID)
-			if err != nil {
-				log.Error().Err(err).Msg("failed to get client")
-				continue
+			// the channel closes once the broker confirmed the cancel
+			for d := range msgs {
+				d.Nack(false, true)
 			}
-			if err := s.sendMessage(ctx, cli, &m); err != nil {
-				log.Error().Err(err).Msg("failed to send message")
+			return nil
+		case d, ok := <-msgs:
+			if !ok {
+				return fmt.Errorf("%s consumer closed", queue)
+			}
+			if handle(work, d) {
+				d.Ack(false)
+			} else {
+				d.Nack(false, true)
 			}
 		}
 	}
 }
 
+// Close disconnects every loaded client and gives up the session leases of
+// this instance so other instances take the sessions over right away.
+func (s *Service) Close(ctx context.Context) {
+	for _, id := range s.clients.ids() {
+		if cli, ok := s.clients.client(id); ok {
+			cli.Disconnect()
+			s.clients.remove(id)
+			s.markDisconnected(id)
+		}
+	}
+	s.owned.clear()
+	if err := s.leaseRepo.ReleaseAll(ctx, s.cfg.InstanceID); err != nil {
+		log.Error().Err(err).Msg("failed to release session leases")
+	}
+}
+
+// handleDelivery sends a message consumed from a send queue, continuing the
+// trace of its publisher. It reports false when shutdown interrupted the
+// send so the message is requeued.
+func (s *Service) handleDelivery(ctx context.Context, d amqp.Delivery) bool {
+	if !d.Timestamp.IsZero() {
+		metrics.QueueLag.WithLabelValues("wpp:send").Observe(time.Since(d.Timestamp).Seconds())
+	}
+	ctx, span := tracing.Start(tracing.Extract(ctx, d.Headers), "consume wpp:send",
+		trace.WithSpanKind(trace.SpanKindConsumer), tracing.QueueAttrs(d.RoutingKey))
+	defer span.End()
+
+	var m OutgoingMessage
+	if err := json.Unmarshal(d.Body, &m); err != nil {
+		log.Error().Err(err).Msg("invalid message payload")
+		span.SetStatus(codes.Error, err.Error())
+		return true
+	}
+	logger := log.With().Str("company_id", m.CompanyID).Str("correlation_id", m.CorrelationID).Logger()
+	ctx = logger.WithContext(ctx)
+	if err := m.Validate(); err != nil {
+		logger.Error().Err(err).Msg("invalid message payload")
+		span.SetStatus(codes.Error, err.Error())
+		s.publishResult(ctx, &m, nil, err)
+		return true
+	}
+	cli, _, err := s.getClient(ctx, m.CompanyID)
+	if ctx.Err() != nil {
+		logger.Warn().Msg("shutting down, message requeued")
+		return false
+	}
+	if errors.Is(err, ErrSessionOwnedElsewhere) {
+		// the lease moved; the new owner consumes the queue from now on
+		logger.Warn().Err(err).Msg("session moved, message requeued")
+		return false
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to get client")
+		span.SetStatus(codes.Error, err.Error())
+		s.publishResult(ctx, &m, nil, err)
+		return true
+	}
+	// wait for the rate limits as long as needed, holding the queue back
+	res, err := s.sendMessage(ctx, cli, &m, 0)
+	if err != nil && ctx.Err() != nil {
+		logger.Warn().Err(err).Msg("shutting down, message requeued")
+		return false
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to send message")
+		span.SetStatus(codes.Error, err.Error())
+	} else if res.Duplicate {
+		logger.Info().Str("msg_id", res.MsgID).Msg("duplicate send skipped")
+	}
+	s.publishResult(ctx, &m, res, err)
+	return true
+}
+
 // getClient returns or creates a WhatsApp client for the given company.
 // If a new login is required the first QR code string is returned.
 func (s *Service) getClient(ctx context.Context, companyID string) (*whatsmeow.Client, string, error) {
-	if c, ok := s.clients[companyID]; ok {
+	if c, ok := s.clients.client(companyID); ok {
 		return c, "", nil
 	}
-
-	var jidStr string
-	_ = s.db.QueryRow(ctx, "SELECT data FROM sessions WHERE company_id=$1", companyID).Scan(&jidStr)
+	if err := s.claim(ctx, companyID); err != nil {
+		return nil, "", err
+	}
 
 	var device *store.Device
-	if jidStr != "" {
-		jid, err := waTypes.ParseJID(jidStr)
+	if sess, err := s.sessionRepo.Get(ctx, companyID); err != nil {
+		log.Error().Err(err).Str("company_id", companyID).Msg("failed to load session")
+	} else if sess != nil {
+		if sess.BannedUntil != nil && time.Now().Before(*sess.BannedUntil) {
+			return nil, "", fmt.Errorf("%w until %s", ErrSessionBanned, sess.BannedUntil.UTC().Format(time.RFC3339))
+		}
+		jid, err := waTypes.ParseJID(sess.JID)
 		if err == nil {
 			device, _ = s.store.GetDevice(ctx, jid)
 		}
@@ -166,7 +533,7 @@ func (s *Service) getClient(ctx context.Context, companyID string) (*whatsmeow.C
 		device = s.store.NewDevice()
 	}
 
-	cli := whatsmeow.NewClient(device, waLog.Stdout("client"+companyID, "INFO", true))
+	cli := whatsmeow.NewClient(device, logging.WA(log.With().Str("company_id", companyID).Str("module", "client").Logger()))
 	cli.AddEventHandler(s.eventHandler(companyID, cli))
 
 	var qr string
@@ -178,7 +545,7 @@ func (s *Service) getClient(ctx context.Context, companyID string) (*whatsmeow.C
 		for evt := range qrChan {
 			if evt.Event == "code" {
 				log.Info().Str("company_id", companyID).Msgf("scan QR: %s", evt.Code)
-				s.publishSessionEvent(companyID, "qr", evt.Code)
+				s.publishSessionEvent(SessionEvent{CompanyID: companyID, Status: "qr", Code: evt.Code})
 				if qr == "" {
 					qr = evt.Code
 				}
@@ -187,20 +554,20 @@ func (s *Service) getClient(ctx context.Context, companyID string) (*whatsmeow.C
 			}
 		}
 		if cli.Store.ID != nil {
-			_, err := s.db.Exec(ctx, `
-                INSERT INTO sessions (company_id, data)
-                VALUES ($1, $2)
-                ON CONFLICT (company_id) DO UPDATE SET data=EXCLUDED.data
-            `, companyID, []byte(cli.Store.ID.String()))
-			if err != nil {
-				log.Error().Err(err).Msg("failed to store session jid")
+			if err := s.sessionRepo.SavePaired(ctx, companyID, cli.Store.ID.String(), cli.Store.Platform); err != nil {
+				log.Error().Err(err).Str("company_id", companyID).Msg("failed to store session jid")
 			}
 		}
 	} else if err := cli.Connect(); err != nil {
+		s.recordError(companyID, err)
 		return nil, "", err
 	}
 
-	s.clients[companyID] = cli
+	if reg := s.clients.add(companyID, cli); reg != cli {
+		// another request loaded the session meanwhile
+		cli.Disconnect()
+		return reg, "", nil
+	}
 	return cli, qr, nil
 }
 
@@ -213,15 +580,38 @@ func (s *Service) eventHandler(companyID string, cli *whatsmeow.Client) func(evt
 			s.handleReceipt(companyID, v)
 		case *waEvents.Disconnected:
 			log.Warn().Str("company_id", companyID).Msg("client disconnected")
-			s.publishSessionEvent(companyID, "disconnected", "")
+			metrics.SessionDisconnects.WithLabelValues(companyID).Inc()
+			s.markDisconnected(companyID)
+			s.publishSessionEvent(SessionEvent{CompanyID: companyID, Status: "disconnected"})
 		case *waEvents.Connected:
 			log.Info().Str("company_id", companyID).Msg("client connected")
-			s.publishSessionEvent(companyID, "connected", "")
+			s.markConnected(companyID, cli)
+			s.publishSessionEvent(SessionEvent{CompanyID: companyID, Status: "connected"})
+		case *waEvents.LoggedOut:
+			s.handleLoggedOut(companyID, cli, v)
+		case *waEvents.StreamReplaced:
+			s.handleStreamReplaced(companyID, cli)
+		case *waEvents.TemporaryBan:
+			s.handleTemporaryBan(companyID, cli, v)
+		case *waEvents.ConnectFailure:
+			s.handleConnectFailure(companyID, cli, v)
+		case *waEvents.ClientOutdated:
+			s.handleConnectFailure(companyID, cli, &waEvents.ConnectFailure{Reason: waEvents.ConnectFailureClientOutdated})
+		case *waEvents.Presence:
+			s.handlePresence(companyID, v)
+		case *waEvents.ChatPresence:
+			s.handleChatPresence(companyID, v)
 		}
 	}
 }
 
 func (s *Service) handleIncoming(companyID string, cli *whatsmeow.Client, evt *waEvents.Message) {
+	ctx, span := tracing.Start(context.Background(), "receive message", trace.WithAttributes(
+		attribute.String("company_id", companyID),
+		attribute.String("msg_id", string(evt.Info.ID)),
+	))
+	defer span.End()
+
 	msg := evt.Message
 	var msgType, content string
 	switch {
@@ -241,22 +631,22 @@ func (s *Service) handleIncoming(companyID string, cli *whatsmeow.Client, evt *w
 	}
 
 	payloadBytes, _ := protojson.Marshal(evt.RawMessage)
-	_ = s.msgRepo.Save(context.Background(), companyID, string(evt.Info.ID), evt.Info.Sender.String(), evt.Info.Chat.String(), msgType, content, string(payloadBytes), "received")
+	_ = s.msgRepo.Save(ctx, companyID, string(evt.Info.ID), evt.Info.Sender.String(), evt.Info.Chat.String(), msgType, content, string(payloadBytes), "received", evt.Info.IsFromMe)
 
 	picURL := ""
 	if pic, err := cli.GetProfilePictureInfo(evt.Info.Sender, nil); err == nil && pic != nil {
 		picURL = pic.URL
 	}
-	_ = s.contactRepo.Upsert(context.Background(), companyID, evt.Info.Sender.String(), evt.Info.PushName, evt.Info.Sender.User, picURL)
+	_ = s.contactRepo.Upsert(ctx, companyID, evt.Info.Sender.String(), evt.Info.PushName, evt.Info.Sender.User, picURL)
 	if evt.Info.Chat.Server == waTypes.GroupServer {
-		_ = s.groupRepo.Upsert(context.Background(), companyID, evt.Info.Chat.String(), evt.Info.PushName)
+		_ = s.groupRepo.Upsert(ctx, companyID, evt.Info.Chat.String(), evt.Info.PushName)
 	}
 
 	switch evt.Info.Edit {
 	case waTypes.EditAttributeMessageEdit:
-		_ = s.msgRepo.UpdateStatus(context.Background(), companyID, string(evt.Info.ID), "edited")
+		_ = s.msgRepo.UpdateStatus(ctx, companyID, string(evt.Info.ID), "edited")
 	case waTypes.EditAttributeSenderRevoke, waTypes.EditAttributeAdminRevoke:
-		_ = s.msgRepo.UpdateStatus(context.Background(), companyID, string(evt.Info.ID), "deleted")
+		_ = s.msgRepo.UpdateStatus(ctx, companyID, string(evt.Info.ID), "deleted")
 	}
 
 	out := IncomingMessage{
@@ -266,32 +656,178 @@ func (s *Service) handleIncoming(companyID string, cli *whatsmeow.Client, evt *w
 		Message:   content,
 		Timestamp: evt.Info.Timestamp.UTC(),
 	}
+	log.Debug().Str("company_id", companyID).Str("msg_id", string(evt.Info.ID)).
+		Str("from", logging.Phone(evt.Info.Sender.String())).Str("type", msgType).
+		Str("content", logging.Content(content)).Msg("message received")
+	metrics.MessagesReceived.WithLabelValues(companyID, msgType).Inc()
 	body, _ := json.Marshal(out)
-	_ = s.mq.Publish("", "wpp:received", body)
+	_ = s.mq.Publish(ctx, "", "wpp:received", body)
+	s.emit(companyID, "message.received", body)
 }
 
 func (s *Service) handleReceipt(companyID string, evt *waEvents.Receipt) {
+	// sender and read-self receipts come from our own devices and say
+	// nothing about the recipient
 	var status string
 	switch evt.Type {
-	case waTypes.ReceiptTypeDelivered, waTypes.ReceiptTypeSender:
+	case waTypes.ReceiptTypeDelivered:
 		status = "delivered"
-	case waTypes.ReceiptTypeRead, waTypes.ReceiptTypeReadSelf, waTypes.ReceiptTypePlayed:
+	case waTypes.ReceiptTypeRead, waTypes.ReceiptTypePlayed:
 		status = "read"
 	}
 	if status == "" {
 		return
 	}
+	participant := ""
+	if evt.IsGroup {
+		participant = evt.Sender.String()
+	}
 	for _, id := range evt.MessageIDs {
-		_ = s.msgRepo.UpdateStatus(context.Background(), companyID, string(id), status)
+		ok, err := s.msgRepo.UpdateReceipt(context.Background(), companyID, string(id), status)
+		if err != nil {
+			log.Error().Err(err).Str("company_id", companyID).Str("msg_id", string(id)).Msg("failed to update message status")
+			continue
+		}
+		if !ok {
+			// not a message we sent
+			continue
+		}
+		s.publishStatus(context.Background(), StatusEvent{
+			MsgID:       string(id),
+			CompanyID:   companyID,
+			Recipient:   evt.Chat.String(),
+			Participant: participant,
+			Status:      status,
+			Timestamp:   evt.Timestamp.UTC(),
+		})
 	}
 }
 
-func (s *Service) sendMessage(ctx context.Context, cli *whatsmeow.Client, m *OutgoingMessage) error {
-	to, err := waTypes.ParseJID(m.To)
+// sendMessage sends m once the rate limits allow it, failing with a
+// RateLimitError when that takes longer than maxWait. A zero maxWait waits
+// as long as needed.
+func (s *Service) sendMessage(ctx context.Context, cli *whatsmeow.Client, m *OutgoingMessage, maxWait time.Duration) (res *SendResult, err error) {
+	ctx, span := tracing.Start(ctx, "send message", trace.WithAttributes(
+		attribute.String("company_id", m.CompanyID),
+		attribute.String("type", m.Type),
+	))
+	start := time.Now()
+	defer func() {
+		tracing.End(span, err)
+		var limited *RateLimitError
+		switch {
+		case errors.As(err, &limited):
+			// rejected before anything was sent
+		case err != nil:
+			metrics.MessagesFailed.WithLabelValues(m.CompanyID, m.Type).Inc()
+		case !res.Duplicate:
+			metrics.MessagesSent.WithLabelValues(m.CompanyID, m.Type).Inc()
+			metrics.SendDuration.WithLabelValues(m.Type).Observe(time.Since(start).Seconds())
+		}
+	}()
+
+	if cli.Store.ID == nil {
+		return nil, s.failUnsent(ctx, cli, m, ErrNotPaired)
+	}
+	to, err := s.resolveRecipient(ctx, cli, m.CompanyID, m.To)
+	if err != nil {
+		return nil, s.failUnsent(ctx, cli, m, err)
+	}
+
+	// Duplicates are answered before the rate limits so retries don't use up
+	// tokens or get rejected.
+	msgID := cli.GenerateMessageID()
+	rowID, existing, err := s.msgRepo.Reserve(ctx, m.CompanyID, msgID, cli.Store.ID.String(), to.String(), m.Type, m.Message, m.IdempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return &SendResult{
+			ID:        existing.ID,
+			MsgID:     existing.MsgID,
+			Recipient: existing.Receiver,
+			Status:    existing.Status,
+			Timestamp: existing.CreatedAt.UTC(),
+			Duplicate: true,
+		}, nil
+	}
+	if err := s.limiter.wait(ctx, m.CompanyID, to.String(), maxWait); err != nil {
+		// nothing was sent, free the idempotency key for the retry
+		if rerr := s.msgRepo.Release(context.WithoutCancel(ctx), m.CompanyID, msgID); rerr != nil {
+			zerolog.Ctx(ctx).Error().Err(rerr).Str("msg_id", msgID).Msg("failed to release reserved message")
+		}
+		return nil, err
+	}
+
+	msg, err := buildMessage(ctx, cli, m)
+	var resp whatsmeow.SendResponse
+	if err == nil {
+		resp, err = s.send(ctx, cli, to, msg, msgID)
+	}
 	if err != nil {
+		_ = s.msgRepo.MarkFailed(context.WithoutCancel(ctx), m.CompanyID, msgID, err.Error())
+		s.publishStatus(ctx, StatusEvent{
+			MsgID:     msgID,
+			CompanyID: m.CompanyID,
+			Recipient: to.String(),
+			Status:    "failed",
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC(),
+		})
+		return nil, err
+	}
+	zerolog.Ctx(ctx).Info().Str("company_id", m.CompanyID).Str("msg_id", msgID).
+		Str("to", logging.Phone(to.String())).Str("type", m.Type).Msg("message sent")
+	payloadBytes, _ := protojson.Marshal(msg)
+	_ = s.msgRepo.MarkSent(ctx, m.CompanyID, msgID, string(payloadBytes))
+	return &SendResult{
+		ID:        rowID,
+		MsgID:     msgID,
+		Recipient: to.String(),
+		Status:    "sent",
+		Timestamp: resp.Timestamp.UTC(),
+	}, nil
+}
+
+// failUnsent records a message that failed before it was reserved as failed
+// and publishes its failed status, returning err. Messages held back by the
+// rate limits or by a shutdown aren't failures.
+func (s *Service) failUnsent(ctx context.Context, cli *whatsmeow.Client, m *OutgoingMessage, err error) error {
+	var limited *RateLimitError
+	if errors.As(err, &limited) || ctx.Err() != nil {
 		return err
 	}
+	msgID := cli.GenerateMessageID()
+	sender := ""
+	if cli.Store.ID != nil {
+		sender = cli.Store.ID.String()
+	}
+	if ferr := s.msgRepo.Fail(context.WithoutCancel(ctx), m.CompanyID, msgID, sender, m.To, m.Type, m.Message, m.IdempotencyKey, err.Error()); ferr != nil {
+		zerolog.Ctx(ctx).Error().Err(ferr).Msg("failed to record failed message")
+	}
+	s.publishStatus(ctx, StatusEvent{
+		MsgID:     msgID,
+		CompanyID: m.CompanyID,
+		Recipient: m.To,
+		Status:    "failed",
+		Error:     err.Error(),
+		Timestamp: time.Now().UTC(),
+	})
+	return err
+}
 
+// send sends msg through WhatsApp in its own span.
+func (s *Service) send(ctx context.Context, cli *whatsmeow.Client, to waTypes.JID, msg *waProto.Message, msgID string) (whatsmeow.SendResponse, error) {
+	ctx, span := tracing.Start(ctx, "whatsapp send", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("msg_id", msgID)))
+	resp, err := cli.SendMessage(ctx, to, msg, whatsmeow.SendRequestExtra{ID: msgID})
+	tracing.End(span, err)
+	return resp, err
+}
+
+// buildMessage converts an outgoing message into its WhatsApp protobuf,
+// uploading media when needed.
+func buildMessage(ctx context.Context, cli *whatsmeow.Client, m *OutgoingMessage) (*waProto.Message, error) {
 	var msg *waProto.Message
 
 	switch m.Type {
@@ -300,11 +836,11 @@ func (s *Service) sendMessage(ctx context.Context, cli *whatsmeow.Client, m *Out
 	case "image":
 		data, err := download(ctx, m.MediaURL)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		up, err := cli.Upload(ctx, data, whatsmeow.MediaImage)
+		up, err := upload(ctx, cli, data, whatsmeow.MediaImage)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		msg = &waProto.Message{ImageMessage: &waProto.ImageMessage{
 			Caption:       proto.String(m.Message),
@@ -319,11 +855,11 @@ func (s *Service) sendMessage(ctx context.Context, cli *whatsmeow.Client, m *Out
 	case "audio":
 		data, err := download(ctx, m.MediaURL)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		up, err := cli.Upload(ctx, data, whatsmeow.MediaAudio)
+		up, err := upload(ctx, cli, data, whatsmeow.MediaAudio)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		msg = &waProto.Message{AudioMessage: &waProto.AudioMessage{
 			Mimetype:      proto.String(http.DetectContentType(data)),
@@ -337,11 +873,11 @@ func (s *Service) sendMessage(ctx context.Context, cli *whatsmeow.Client, m *Out
 	case "document":
 		data, err := download(ctx, m.MediaURL)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		up, err := cli.Upload(ctx, data, whatsmeow.MediaDocument)
+		up, err := upload(ctx, cli, data, whatsmeow.MediaDocument)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		msg = &waProto.Message{DocumentMessage: &waProto.DocumentMessage{
 			FileName:      proto.String(m.Filename),
@@ -354,43 +890,154 @@ func (s *Service) sendMessage(ctx context.Context, cli *whatsmeow.Client, m *Out
 			FileLength:    &up.FileLength,
 		}}
 	default:
-		return fmt.Errorf("unknown message type %s", m.Type)
+		return nil, fmt.Errorf("unknown message type %s", m.Type)
 	}
 
-	resp, err := cli.SendMessage(ctx, to, msg)
-	if err == nil {
-		payloadBytes, _ := protojson.Marshal(msg)
-		_ = s.msgRepo.Save(ctx, m.CompanyID, resp.ID, cli.Store.ID.String(), to.String(), m.Type, m.Message, string(payloadBytes), "sent")
+	return msg, nil
+}
+
+func (s *Service) publishSessionEvent(evt SessionEvent) {
+	body, _ := json.Marshal(evt)
+	if err := s.mq.Publish(context.Background(), "", "wpp:sessions", body); err != nil {
+		log.Error().Err(err).Str("company_id", evt.CompanyID).Msg("failed to publish session event")
 	}
-	return err
+	s.emit(evt.CompanyID, "session", body)
 }
 
-func (s *Service) publishSessionEvent(companyID, status, code string) {
-	evt := map[string]string{
-		"company_id": companyID,
-		"status":     status,
+// publishResult emits a message.sent or message.failed event for a message
+// consumed from the queue.
+func (s *Service) publishResult(ctx context.Context, m *OutgoingMessage, res *SendResult, sendErr error) {
+	evt := ResultEvent{
+		Event:         "message.sent",
+		CompanyID:     m.CompanyID,
+		CorrelationID: m.CorrelationID,
+		SendResult:    res,
 	}
-	if code != "" {
-		evt["code"] = code
+	if sendErr != nil {
+		evt.Event = "message.failed"
+		evt.Error = sendErr.Error()
 	}
 	body, _ := json.Marshal(evt)
-	if err := s.mq.Publish("", "wpp:sessions", body); err != nil {
-		log.Error().Err(err).Msg("failed to publish session event")
+	if err := s.mq.Publish(ctx, "", "wpp:results", body); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to publish result event")
+	}
+	s.emit(m.CompanyID, evt.Event, body)
+}
+
+// publishStatus emits a message.status event to the wpp:status queue and the
+// company's webhooks.
+func (s *Service) publishStatus(ctx context.Context, evt StatusEvent) {
+	evt.Event = "message.status"
+	body, _ := json.Marshal(evt)
+	if err := s.mq.Publish(ctx, "", "wpp:status", body); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("company_id", evt.CompanyID).Str("msg_id", evt.MsgID).Msg("failed to publish status event")
+	}
+	s.emit(evt.CompanyID, "message.status", body)
+}
+
+// emit delivers an event to the company's webhooks and to the streaming
+// subscribers of every instance.
+func (s *Service) emit(companyID, event string, body []byte) {
+	s.hooks.Dispatch(companyID, event, body)
+	s.stream(companyID, event, body)
+}
+
+// stream delivers an event to the in-process subscribers and broadcasts it
+// to the other instances.
+func (s *Service) stream(companyID, event string, body []byte) {
+	evt := s.events.Publish(event, companyID, body)
+	data, _ := json.Marshal(evt)
+	if err := s.mq.Broadcast(context.Background(), eventsExchange, s.cfg.InstanceID, data); err != nil {
+		log.Error().Err(err).Str("company_id", companyID).Str("event", event).Msg("failed to broadcast event")
 	}
 }
 
-func download(ctx context.Context, url string) ([]byte, error) {
+// relayEvents delivers the events other instances broadcast, consumed by
+// the subscription with tag, to the in-process subscribers until ctx is done.
+func (s *Service) relayEvents(ctx context.Context, tag string, msgs <-chan amqp.Delivery) {
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.mq.Cancel(tag); err != nil {
+				log.Error().Err(err).Str("exchange", eventsExchange).Msg("failed to stop consuming")
+				return
+			}
+			for d := range msgs {
+				d.Ack(false)
+			}
+			return
+		case d, ok := <-msgs:
+			if !ok {
+				log.Error().Str("exchange", eventsExchange).Msg("events subscription closed, streams only get local events")
+				return
+			}
+			s.relay(d)
+			d.Ack(false)
+		}
+	}
+}
+
+// relay delivers an event broadcast by another instance to the in-process
+// subscribers. Our own events were delivered when they happened.
+func (s *Service) relay(d amqp.Delivery) {
+	if d.AppId == s.cfg.InstanceID {
+		return
+	}
+	var evt events.Event
+	if err := json.Unmarshal(d.Body, &evt); err != nil {
+		log.Error().Err(err).Str("exchange", eventsExchange).Msg("invalid event")
+		return
+	}
+	s.events.Deliver(evt)
+}
+
+// mediaNames labels the upload metrics.
+var mediaNames = map[whatsmeow.MediaType]string{
+	whatsmeow.MediaImage:    "image",
+	whatsmeow.MediaAudio:    "audio",
+	whatsmeow.MediaDocument: "document",
+}
+
+// upload uploads media to WhatsApp, recording its latency and size.
+func upload(ctx context.Context, cli *whatsmeow.Client, data []byte, mt whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	ctx, span := tracing.Start(ctx, "media upload", trace.WithAttributes(
+		attribute.String("media", mediaNames[mt]),
+		attribute.Int("size", len(data)),
+	))
+	start := time.Now()
+	up, err := cli.Upload(ctx, data, mt)
+	tracing.End(span, err)
+	if err == nil {
+		metrics.MediaUploadDuration.WithLabelValues(mediaNames[mt]).Observe(time.Since(start).Seconds())
+		metrics.MediaUploadBytes.WithLabelValues(mediaNames[mt]).Observe(float64(len(data)))
+	}
+	return up, err
+}
+
+func download(ctx context.Context, url string) (data []byte, err error) {
+	ctx, span := tracing.Start(ctx, "media download", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() {
+		span.SetAttributes(attribute.Int("size", len(data)))
+		tracing.End(span, err)
+	}()
+	start := time.Now()
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrMediaDownload, err)
 	}
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrMediaDownload, err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		return nil, fmt.Errorf("%w: unexpected status code %d", ErrMediaDownload, resp.StatusCode)
+	}
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMediaDownload, err)
 	}
-	return io.ReadAll(resp.Body)
+	metrics.MediaDownloadDuration.Observe(time.Since(start).Seconds())
+	metrics.MediaDownloadBytes.Observe(float64(len(data)))
+	return data, nil
 }
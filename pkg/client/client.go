@@ -0,0 +1,58 @@
+// Package client is a Go client for the wpp-wave-bot admin API. It is
+// generated from internal/api/openapi.json, which is served at /openapi.json;
+// run go generate after changing the spec.
+package client
+
+//go:generate go run github.com/oapi-codegen/oapi-codegen/v2/cmd/oapi-codegen@v2.5.0 -config oapi-codegen.yaml ../../internal/api/openapi.json
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// New creates a client for the API at baseURL authenticating with key,
+// either an API key or a JWT.
+func New(baseURL, key string, opts ...ClientOption) (*ClientWithResponses, error) {
+	bearer := WithRequestEditorFn(func(ctx context.Context, req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+key)
+		return nil
+	})
+	return NewClientWithResponses(baseURL, append([]ClientOption{bearer}, opts...)...)
+}
+
+// APIError is an error response of the API.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Field      string
+	RequestID  string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("wpp-wave-bot: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// CheckResponse returns an *APIError for responses outside the 2xx range,
+// such as CheckResponse(res.StatusCode(), res.Body).
+func CheckResponse(statusCode int, body []byte) error {
+	if statusCode >= 200 && statusCode < 300 {
+		return nil
+	}
+	e := &APIError{StatusCode: statusCode}
+	var env Error
+	if err := json.Unmarshal(body, &env); err != nil || env.Error.Code == "" {
+		e.Code, e.Message = "unknown", http.StatusText(statusCode)
+		return e
+	}
+	e.Code, e.Message = string(env.Error.Code), env.Error.Message
+	if env.Error.Field != nil {
+		e.Field = *env.Error.Field
+	}
+	if env.Error.RequestId != nil {
+		e.RequestID = *env.Error.RequestId
+	}
+	return e
+}
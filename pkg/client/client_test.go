@@ -0,0 +1,218 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/example/wpp-wave-bot/internal/api"
+	"github.com/example/wpp-wave-bot/internal/auth"
+)
+
+// newTestServer serves the API without backends, accepting JWTs signed by a
+// generated key, and returns its URL and a token for the company.
+func newTestServer(t *testing.T, companyID string) (string, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kid": "test", "kty": "RSA", "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := auth.NewJWTVerifier(context.Background(), auth.JWTConfig{JWKSFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub":        "tester",
+		"company_id": companyID,
+		"exp":        time.Now().Add(time.Hour).Unix(),
+	})
+	tok.Header["kid"] = "test"
+	signed, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(api.New(nil, nil, nil, verifier).Handler())
+	t.Cleanup(srv.Close)
+	return srv.URL, signed
+}
+
+func TestClientPublicRoutes(t *testing.T) {
+	url, _ := newTestServer(t, "empresa-123")
+	c, err := NewClientWithResponses(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	live, err := c.LivezWithResponse(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live.JSON200 == nil || live.JSON200.Status == nil || *live.JSON200.Status != "ok" {
+		t.Errorf("Livez = %d %s", live.StatusCode(), live.Body)
+	}
+
+	spec, err := c.GetOpenAPIWithResponse(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+	}
+	if err := json.Unmarshal(spec.Body, &doc); err != nil || doc.OpenAPI == "" {
+		t.Errorf("GetOpenAPI = %d %s", spec.StatusCode(), spec.Body)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	url, token := newTestServer(t, "empresa-123")
+	ctx := context.Background()
+	anonymous, err := NewClientWithResponses(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(url, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		call   func() (int, []byte, *Error, error)
+		status int
+		code   string
+		field  string
+	}{
+		{
+			name: "missing credentials",
+			call: func() (int, []byte, *Error, error) {
+				res, err := anonymous.ListSessionsWithResponse(ctx)
+				if err != nil {
+					return 0, nil, nil, err
+				}
+				return res.StatusCode(), res.Body, res.JSON401, nil
+			},
+			status: http.StatusUnauthorized,
+			code:   "unauthorized",
+		},
+		{
+			name: "other company",
+			call: func() (int, []byte, *Error, error) {
+				res, err := c.ListMessagesWithResponse(ctx, "empresa-456", nil)
+				if err != nil {
+					return 0, nil, nil, err
+				}
+				return res.StatusCode(), res.Body, res.JSON403, nil
+			},
+			status: http.StatusForbidden,
+			code:   "forbidden",
+		},
+		{
+			name: "admin route",
+			call: func() (int, []byte, *Error, error) {
+				res, err := c.ListAPIKeysWithResponse(ctx)
+				if err != nil {
+					return 0, nil, nil, err
+				}
+				return res.StatusCode(), res.Body, res.JSON403, nil
+			},
+			status: http.StatusForbidden,
+			code:   "forbidden",
+		},
+		{
+			name: "invalid message",
+			call: func() (int, []byte, *Error, error) {
+				key := "pedido-42"
+				res, err := c.SendMessageWithResponse(ctx, &SendMessageParams{IdempotencyKey: &key}, OutgoingMessage{
+					CompanyId: "empresa-123",
+					To:        "5511999999999",
+					Type:      "text",
+				})
+				if err != nil {
+					return 0, nil, nil, err
+				}
+				return res.StatusCode(), res.Body, res.JSON400, nil
+			},
+			status: http.StatusBadRequest,
+			code:   "validation_failed",
+			field:  "message",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body, decoded, err := tt.call()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d: %s", status, tt.status, body)
+			}
+			if decoded == nil || string(decoded.Error.Code) != tt.code {
+				t.Errorf("decoded error = %+v, want code %s", decoded, tt.code)
+			}
+
+			var apiErr *APIError
+			if err := CheckResponse(status, body); !errors.As(err, &apiErr) {
+				t.Fatalf("CheckResponse = %v, want *APIError", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Code != tt.code || apiErr.Field != tt.field || apiErr.RequestID == "" {
+				t.Errorf("CheckResponse = %+v", apiErr)
+			}
+		})
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		code   string
+	}{
+		{"ok", http.StatusOK, `{"status":"ok"}`, ""},
+		{"no content", http.StatusNoContent, "", ""},
+		{"error envelope", http.StatusTooManyRequests, `{"error":{"code":"rate_limited","message":"slow down"}}`, "rate_limited"},
+		{"not json", http.StatusBadGateway, "<html>bad gateway</html>", "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckResponse(tt.status, []byte(tt.body))
+			if tt.code == "" {
+				if err != nil {
+					t.Errorf("CheckResponse = %v, want nil", err)
+				}
+				return
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.Code != tt.code || !strings.Contains(err.Error(), tt.code) {
+				t.Errorf("CheckResponse = %v, want code %s", err, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,423 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: wppwavebot/v1/wppwavebot.proto
+
+package wppwavebotv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	WhatsApp_Connect_FullMethodName          = "/wppwavebot.v1.WhatsApp/Connect"
+	WhatsApp_Logout_FullMethodName           = "/wppwavebot.v1.WhatsApp/Logout"
+	WhatsApp_GetSessionStatus_FullMethodName = "/wppwavebot.v1.WhatsApp/GetSessionStatus"
+	WhatsApp_ListSessions_FullMethodName     = "/wppwavebot.v1.WhatsApp/ListSessions"
+	WhatsApp_StreamQR_FullMethodName         = "/wppwavebot.v1.WhatsApp/StreamQR"
+	WhatsApp_SendMessage_FullMethodName      = "/wppwavebot.v1.WhatsApp/SendMessage"
+	WhatsApp_SubscribeEvents_FullMethodName  = "/wppwavebot.v1.WhatsApp/SubscribeEvents"
+)
+
+// WhatsAppClient is the client API for WhatsApp service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// WhatsApp exposes the sessions and messages of wpp-wave-bot. Calls are
+// authenticated with an API key or JWT in the "authorization" metadata,
+// e.g. "Bearer wwb_...".
+type WhatsAppClient interface {
+	// Connect creates or restores the session of a company. The first QR
+	// code is returned when the session still has to be paired.
+	Connect(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*ConnectResponse, error)
+	// Logout logs out the session of a company and forgets its device.
+	Logout(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*LogoutResponse, error)
+	// GetSessionStatus reports the state and device of a session.
+	GetSessionStatus(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*SessionStatus, error)
+	// ListSessions reports every loaded or paired session visible to the
+	// caller.
+	ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (*ListSessionsResponse, error)
+	// StreamQR connects the session and streams every QR code until it is
+	// paired, the codes expire or the call is cancelled.
+	StreamQR(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (WhatsApp_StreamQRClient, error)
+	// SendMessage sends a message right away.
+	SendMessage(ctx context.Context, in *SendMessageRequest, opts ...grpc.CallOption) (*SendMessageResponse, error)
+	// SubscribeEvents streams received messages, delivery receipts, send
+	// results, session events and presence updates.
+	SubscribeEvents(ctx context.Context, in *SubscribeEventsRequest, opts ...grpc.CallOption) (WhatsApp_SubscribeEventsClient, error)
+}
+
+type whatsAppClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewWhatsAppClient(cc grpc.ClientConnInterface) WhatsAppClient {
+	return &whatsAppClient{cc}
+}
+
+func (c *whatsAppClient) Connect(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*ConnectResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ConnectResponse)
+	err := c.cc.Invoke(ctx, WhatsApp_Connect_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *whatsAppClient) Logout(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*LogoutResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LogoutResponse)
+	err := c.cc.Invoke(ctx, WhatsApp_Logout_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *whatsAppClient) GetSessionStatus(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*SessionStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SessionStatus)
+	err := c.cc.Invoke(ctx, WhatsApp_GetSessionStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *whatsAppClient) ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (*ListSessionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSessionsResponse)
+	err := c.cc.Invoke(ctx, WhatsApp_ListSessions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *whatsAppClient) StreamQR(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (WhatsApp_StreamQRClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &WhatsApp_ServiceDesc.Streams[0], WhatsApp_StreamQR_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &whatsAppStreamQRClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type WhatsApp_StreamQRClient interface {
+	Recv() (*QREvent, error)
+	grpc.ClientStream
+}
+
+type whatsAppStreamQRClient struct {
+	grpc.ClientStream
+}
+
+func (x *whatsAppStreamQRClient) Recv() (*QREvent, error) {
+	m := new(QREvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *whatsAppClient) SendMessage(ctx context.Context, in *SendMessageRequest, opts ...grpc.CallOption) (*SendMessageResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SendMessageResponse)
+	err := c.cc.Invoke(ctx, WhatsApp_SendMessage_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *whatsAppClient) SubscribeEvents(ctx context.Context, in *SubscribeEventsRequest, opts ...grpc.CallOption) (WhatsApp_SubscribeEventsClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &WhatsApp_ServiceDesc.Streams[1], WhatsApp_SubscribeEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &whatsAppSubscribeEventsClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type WhatsApp_SubscribeEventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type whatsAppSubscribeEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *whatsAppSubscribeEventsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WhatsAppServer is the server API for WhatsApp service.
+// All implementations must embed UnimplementedWhatsAppServer
+// for forward compatibility
+//
+// WhatsApp exposes the sessions and messages of wpp-wave-bot. Calls are
+// authenticated with an API key or JWT in the "authorization" metadata,
+// e.g. "Bearer wwb_...".
+type WhatsAppServer interface {
+	// Connect creates or restores the session of a company. The first QR
+	// code is returned when the session still has to be paired.
+	Connect(context.Context, *SessionRequest) (*ConnectResponse, error)
+	// Logout logs out the session of a company and forgets its device.
+	Logout(context.Context, *SessionRequest) (*LogoutResponse, error)
+	// GetSessionStatus reports the state and device of a session.
+	GetSessionStatus(context.Context, *SessionRequest) (*SessionStatus, error)
+	// ListSessions reports every loaded or paired session visible to the
+	// caller.
+	ListSessions(context.Context, *ListSessionsRequest) (*ListSessionsResponse, error)
+	// StreamQR connects the session and streams every QR code until it is
+	// paired, the codes expire or the call is cancelled.
+	StreamQR(*SessionRequest, WhatsApp_StreamQRServer) error
+	// SendMessage sends a message right away.
+	SendMessage(context.Context, *SendMessageRequest) (*SendMessageResponse, error)
+	// SubscribeEvents streams received messages, delivery receipts, send
+	// results, session events and presence updates.
+	SubscribeEvents(*SubscribeEventsRequest, WhatsApp_SubscribeEventsServer) error
+	mustEmbedUnimplementedWhatsAppServer()
+}
+
+// UnimplementedWhatsAppServer must be embedded to have forward compatible implementations.
+type UnimplementedWhatsAppServer struct {
+}
+
+func (UnimplementedWhatsAppServer) Connect(context.Context, *SessionRequest) (*ConnectResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Connect not implemented")
+}
+func (UnimplementedWhatsAppServer) Logout(context.Context, *SessionRequest) (*LogoutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Logout not implemented")
+}
+func (UnimplementedWhatsAppServer) GetSessionStatus(context.Context, *SessionRequest) (*SessionStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSessionStatus not implemented")
+}
+func (UnimplementedWhatsAppServer) ListSessions(context.Context, *ListSessionsRequest) (*ListSessionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSessions not implemented")
+}
+func (UnimplementedWhatsAppServer) StreamQR(*SessionRequest, WhatsApp_StreamQRServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamQR not implemented")
+}
+func (UnimplementedWhatsAppServer) SendMessage(context.Context, *SendMessageRequest) (*SendMessageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendMessage not implemented")
+}
+func (UnimplementedWhatsAppServer) SubscribeEvents(*SubscribeEventsRequest, WhatsApp_SubscribeEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeEvents not implemented")
+}
+func (UnimplementedWhatsAppServer) mustEmbedUnimplementedWhatsAppServer() {}
+
+// UnsafeWhatsAppServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to WhatsAppServer will
+// result in compilation errors.
+type UnsafeWhatsAppServer interface {
+	mustEmbedUnimplementedWhatsAppServer()
+}
+
+func RegisterWhatsAppServer(s grpc.ServiceRegistrar, srv WhatsAppServer) {
+	s.RegisterService(&WhatsApp_ServiceDesc, srv)
+}
+
+func _WhatsApp_Connect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WhatsAppServer).Connect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WhatsApp_Connect_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WhatsAppServer).Connect(ctx, req.(*SessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WhatsApp_Logout_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WhatsAppServer).Logout(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WhatsApp_Logout_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WhatsAppServer).Logout(ctx, req.(*SessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WhatsApp_GetSessionStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WhatsAppServer).GetSessionStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WhatsApp_GetSessionStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WhatsAppServer).GetSessionStatus(ctx, req.(*SessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WhatsApp_ListSessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSessionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WhatsAppServer).ListSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WhatsApp_ListSessions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WhatsAppServer).ListSessions(ctx, req.(*ListSessionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WhatsApp_StreamQR_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SessionRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WhatsAppServer).StreamQR(m, &whatsAppStreamQRServer{ServerStream: stream})
+}
+
+type WhatsApp_StreamQRServer interface {
+	Send(*QREvent) error
+	grpc.ServerStream
+}
+
+type whatsAppStreamQRServer struct {
+	grpc.ServerStream
+}
+
+func (x *whatsAppStreamQRServer) Send(m *QREvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _WhatsApp_SendMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendMessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WhatsAppServer).SendMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WhatsApp_SendMessage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WhatsAppServer).SendMessage(ctx, req.(*SendMessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WhatsApp_SubscribeEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WhatsAppServer).SubscribeEvents(m, &whatsAppSubscribeEventsServer{ServerStream: stream})
+}
+
+type WhatsApp_SubscribeEventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type whatsAppSubscribeEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *whatsAppSubscribeEventsServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// WhatsApp_ServiceDesc is the grpc.ServiceDesc for WhatsApp service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var WhatsApp_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "wppwavebot.v1.WhatsApp",
+	HandlerType: (*WhatsAppServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Connect",
+			Handler:    _WhatsApp_Connect_Handler,
+		},
+		{
+			MethodName: "Logout",
+			Handler:    _WhatsApp_Logout_Handler,
+		},
+		{
+			MethodName: "GetSessionStatus",
+			Handler:    _WhatsApp_GetSessionStatus_Handler,
+		},
+		{
+			MethodName: "ListSessions",
+			Handler:    _WhatsApp_ListSessions_Handler,
+		},
+		{
+			MethodName: "SendMessage",
+			Handler:    _WhatsApp_SendMessage_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamQR",
+			Handler:       _WhatsApp_StreamQR_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "SubscribeEvents",
+			Handler:       _WhatsApp_SubscribeEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "wppwavebot/v1/wppwavebot.proto",
+}